@@ -0,0 +1,51 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// GRPC checks the server using the standard grpc.health.v1.Health service
+type GRPC struct {
+	name    string
+	service string
+	client  healthpb.HealthClient
+}
+
+// New creates the checker of service served by conn. Empty service checks the overall health of the server
+func New(conn grpc.ClientConnInterface, service string) *GRPC {
+	return &GRPC{service: service, client: healthpb.NewHealthClient(conn)}
+}
+
+// SetName ...
+func (g *GRPC) SetName(name string) {
+	g.name = name
+}
+
+// Name ...
+func (g *GRPC) Name() string {
+	if len(g.name) == 0 {
+		return "GRPC"
+	}
+	return g.name
+}
+
+// Check ...
+func (g *GRPC) Check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := g.client.Check(ctx, &healthpb.HealthCheckRequest{Service: g.service})
+	if err != nil {
+		return err
+	}
+
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("Status %s", res.GetStatus())
+	}
+	return nil
+}
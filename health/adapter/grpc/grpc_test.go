@@ -0,0 +1,67 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newServer(t *testing.T) (*health.Server, *grpc.ClientConn, func()) {
+	var (
+		listener = bufconn.Listen(1024 * 1024)
+		server   = grpc.NewServer()
+		hs       = health.NewServer()
+	)
+	healthpb.RegisterHealthServer(server, hs)
+	go server.Serve(listener)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hs, conn, func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestGRPC_GetSetName(t *testing.T) {
+	checker := New(nil, "")
+	assert.Equal(t, "GRPC", checker.Name())
+
+	const name = "GRPC accounts 127.0.0.1:9090"
+	checker.SetName(name)
+	assert.Equal(t, name, checker.Name())
+}
+
+func TestGRPC_Check(t *testing.T) {
+	hs, conn, stop := newServer(t)
+	defer stop()
+
+	// the overall health is serving by default
+	assert.Nil(t, New(conn, "").Check())
+
+	hs.SetServingStatus("accounts.v1.Accounts", healthpb.HealthCheckResponse_SERVING)
+	assert.Nil(t, New(conn, "accounts.v1.Accounts").Check())
+
+	hs.SetServingStatus("accounts.v1.Accounts", healthpb.HealthCheckResponse_NOT_SERVING)
+	assert.EqualError(t, New(conn, "accounts.v1.Accounts").Check(), "Status NOT_SERVING")
+
+	err := New(conn, "unknown.v1.Unknown").Check()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
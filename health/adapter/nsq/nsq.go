@@ -0,0 +1,157 @@
+package nsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NSQ checks nsqd or nsqlookupd using its HTTP /ping endpoint. When the max depth is set, nsqd /stats is also
+// queried and the check fails if the depth of the topic or channel exceeds the max
+type NSQ struct {
+	addr      string
+	name      string
+	maxDepths []maxDepth
+	client    interface {
+		Do(req *http.Request) (*http.Response, error)
+	}
+}
+
+// maxDepth is the max depth of the channel of topic, empty channel is the depth of the topic itself
+type maxDepth struct {
+	topic   string
+	channel string
+	max     int64
+}
+
+// stats is the part of the response of nsqd /stats?format=json which is used by the check.
+// nsqd older than v1.0 wraps it inside "data"
+type stats struct {
+	Topics []struct {
+		Name     string `json:"topic_name"`
+		Depth    int64  `json:"depth"`
+		Channels []struct {
+			Name  string `json:"channel_name"`
+			Depth int64  `json:"depth"`
+		} `json:"channels"`
+	} `json:"topics"`
+	Data *stats `json:"data"`
+}
+
+// New creates the checker of the HTTP address of nsqd or nsqlookupd, e.g. "http://127.0.0.1:4151"
+func New(addr string) *NSQ {
+	return &NSQ{addr: strings.TrimSuffix(addr, "/")}
+}
+
+// SetName ...
+func (n *NSQ) SetName(name string) {
+	n.name = name
+}
+
+// Name ...
+func (n *NSQ) Name() string {
+	if len(n.name) == 0 {
+		return "NSQ"
+	}
+	return n.name
+}
+
+// SetMaxDepth fails the check when the depth of the channel of topic exceeds max. Empty channel checks the depth
+// of the topic. The topic or channel which does not exist is not checked. It only works against nsqd
+func (n *NSQ) SetMaxDepth(topic, channel string, max int64) {
+	n.maxDepths = append(n.maxDepths, maxDepth{topic: topic, channel: channel, max: max})
+}
+
+// Check ...
+func (n *NSQ) Check() error {
+	if n.client == nil {
+		n.client = newClient()
+	}
+
+	if _, err := n.get("/ping"); err != nil {
+		return err
+	}
+
+	for _, v := range n.maxDepths {
+		if err := n.checkDepth(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDepth queries the stats of the topic of d from nsqd and compares its depth with the max
+func (n *NSQ) checkDepth(d maxDepth) error {
+	query := url.Values{"format": {"json"}, "topic": {d.topic}}
+	if d.channel != "" {
+		query.Set("channel", d.channel)
+	}
+
+	b, err := n.get("/stats?" + query.Encode())
+	if err != nil {
+		return err
+	}
+
+	var s stats
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("Invalid stats: %s", err)
+	}
+	if s.Data != nil {
+		s = *s.Data
+	}
+
+	for _, topic := range s.Topics {
+		if topic.Name != d.topic {
+			continue
+		}
+		if d.channel == "" {
+			if topic.Depth > d.max {
+				return fmt.Errorf("Topic %s depth %d exceeds %d", d.topic, topic.Depth, d.max)
+			}
+			return nil
+		}
+
+		for _, channel := range topic.Channels {
+			if channel.Name == d.channel && channel.Depth > d.max {
+				return fmt.Errorf("Channel %s/%s depth %d exceeds %d", d.topic, d.channel, channel.Depth, d.max)
+			}
+		}
+	}
+	return nil
+}
+
+func (n *NSQ) get(path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, n.addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Status Code %d: %s", res.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+func newClient() *http.Client {
+	return &http.Client{
+		Timeout: time.Second * 5,
+		Transport: &http.Transport{
+			MaxIdleConns:        1,
+			MaxIdleConnsPerHost: 1,
+		},
+	}
+}
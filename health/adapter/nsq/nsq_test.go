@@ -0,0 +1,95 @@
+package nsq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const statsBody = `{
+	"version": "1.2.1",
+	"health": "OK",
+	"topics": [{
+		"topic_name": "ACCOUNTS_REGISTRATION",
+		"depth": 12,
+		"channels": [
+			{"channel_name": "mailer", "depth": 150},
+			{"channel_name": "audit", "depth": 3}
+		]
+	}]
+}`
+
+func newNSQD(t *testing.T, statsBody string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+			w.Write([]byte("OK"))
+		case "/stats":
+			assert.Equal(t, "json", r.URL.Query().Get("format"))
+			assert.Equal(t, "ACCOUNTS_REGISTRATION", r.URL.Query().Get("topic"))
+			w.Write([]byte(statsBody))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestNew(t *testing.T) {
+	assert.Equal(t, &NSQ{addr: "http://127.0.0.1:4151"}, New("http://127.0.0.1:4151/"))
+}
+
+func TestNSQ_GetSetName(t *testing.T) {
+	nsq := New("http://127.0.0.1:4151")
+	assert.Equal(t, "NSQ", nsq.Name())
+
+	const name = "NSQD 127.0.0.1:4151"
+	nsq.SetName(name)
+	assert.Equal(t, name, nsq.Name())
+}
+
+func TestNSQ_Check(t *testing.T) {
+	server := newNSQD(t, statsBody)
+	defer server.Close()
+
+	t.Run("Ping", func(t *testing.T) {
+		assert.Nil(t, New(server.URL).Check())
+	})
+
+	t.Run("Depth", func(t *testing.T) {
+		nsq := New(server.URL)
+		nsq.SetMaxDepth("ACCOUNTS_REGISTRATION", "", 100)
+		nsq.SetMaxDepth("ACCOUNTS_REGISTRATION", "audit", 100)
+		nsq.SetMaxDepth("ACCOUNTS_REGISTRATION", "unknown", 0)
+		assert.Nil(t, nsq.Check())
+
+		nsq.SetMaxDepth("ACCOUNTS_REGISTRATION", "mailer", 100)
+		assert.EqualError(t, nsq.Check(), "Channel ACCOUNTS_REGISTRATION/mailer depth 150 exceeds 100")
+
+		nsq = New(server.URL)
+		nsq.SetMaxDepth("ACCOUNTS_REGISTRATION", "", 10)
+		assert.EqualError(t, nsq.Check(), "Topic ACCOUNTS_REGISTRATION depth 12 exceeds 10")
+	})
+
+	t.Run("LegacyStats", func(t *testing.T) {
+		server := newNSQD(t, `{"status_code": 200, "status_txt": "OK", "data": `+statsBody+`}`)
+		defer server.Close()
+
+		nsq := New(server.URL)
+		nsq.SetMaxDepth("ACCOUNTS_REGISTRATION", "", 10)
+		assert.EqualError(t, nsq.Check(), "Topic ACCOUNTS_REGISTRATION depth 12 exceeds 10")
+	})
+
+	t.Run("Down", func(t *testing.T) {
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("NOK - unable to write"))
+		}))
+		defer down.Close()
+		assert.EqualError(t, New(down.URL).Check(), "Status Code 500: NOK - unable to write")
+
+		down.Close()
+		assert.NotNil(t, New(down.URL).Check())
+	})
+}
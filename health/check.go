@@ -0,0 +1,186 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the timeout of the checker which is not registered using WithTimeout, see Health.SetTimeout
+const DefaultTimeout = 5 * time.Second
+
+// Status is the status of the checker and the health report
+type Status string
+
+// List of Status of the checker
+const (
+	StatusOK   Status = "ok"
+	StatusFail Status = "fail"
+)
+
+// List of Status of the health report
+const (
+	// StatusHealthy means every checker is ok
+	StatusHealthy Status = "healthy"
+	// StatusDegraded means only the non-critical checkers fail, the health is still served with 200
+	StatusDegraded Status = "degraded"
+	// StatusUnhealthy means a critical checker fails, the health is served with 503
+	StatusUnhealthy Status = "unhealthy"
+)
+
+// Result is the last result of a checker
+type Result struct {
+	Name        string
+	Status      Status
+	Critical    bool
+	Latency     time.Duration
+	Error       string
+	LastSuccess time.Time
+}
+
+// MarshalJSON writes the latency as duration string, e.g. "1.5ms", and omits zero LastSuccess
+func (r Result) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Name        string     `json:"name"`
+		Status      Status     `json:"status"`
+		Critical    bool       `json:"critical"`
+		Latency     string     `json:"latency"`
+		Error       string     `json:"error,omitempty"`
+		LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	}{
+		Name:     r.Name,
+		Status:   r.Status,
+		Critical: r.Critical,
+		Latency:  r.Latency.String(),
+		Error:    r.Error,
+	}
+	if !r.LastSuccess.IsZero() {
+		v.LastSuccess = &r.LastSuccess
+	}
+	return json.Marshal(v)
+}
+
+// Report is the result of every checker
+type Report struct {
+	Status Status   `json:"status"`
+	Error  string   `json:"error,omitempty"`
+	Checks []Result `json:"checks,omitempty"`
+}
+
+// option is the checker wrapped by WithTimeout or NonCritical,
+// it configures the check of the wrapped checker on Register
+type option struct {
+	Checker
+	apply func(*check)
+}
+
+// WithTimeout wraps c so its Check is given up after timeout instead of the timeout of the health
+func WithTimeout(c Checker, timeout time.Duration) Checker {
+	return &option{Checker: c, apply: func(ck *check) {
+		ck.timeout = timeout
+	}}
+}
+
+// NonCritical wraps c so its failure only degrades the health instead of making it unhealthy,
+// e.g. the optional dependency which the service can work without
+func NonCritical(c Checker) Checker {
+	return &option{Checker: c, apply: func(ck *check) {
+		ck.nonCritical = true
+	}}
+}
+
+// probe is the set of the endpoints checking the checker
+type probe int
+
+// List of probe
+const (
+	probeLiveness probe = 1 << iota
+	probeReadiness
+)
+
+// check runs the registered checker and keeps its last result
+type check struct {
+	checker     Checker
+	probes      probe
+	timeout     time.Duration
+	nonCritical bool
+
+	mu          sync.Mutex
+	done        chan struct{}
+	err         error
+	latency     time.Duration
+	lastSuccess time.Time
+	state       Status
+	streak      int
+}
+
+// newCheck unwraps the options of c checked by probes p
+func newCheck(c Checker, p probe) *check {
+	ck := &check{probes: p}
+	for {
+		o, ok := c.(*option)
+		if !ok {
+			break
+		}
+		o.apply(ck)
+		c = o.Checker
+	}
+	ck.checker = c
+	return ck
+}
+
+// run calls Check and waits for it up to the timeout, or timeout when the check has no own timeout.
+// The hung Check keeps running in the background and the next run waits for it instead of calling Check again
+func (c *check) run(timeout time.Duration) Result {
+	if c.timeout > 0 {
+		timeout = c.timeout
+	}
+
+	c.mu.Lock()
+	if c.done == nil {
+		done := make(chan struct{})
+		c.done = done
+		go func() {
+			start := time.Now()
+			err := c.checker.Check()
+
+			c.mu.Lock()
+			c.err, c.latency = err, time.Since(start)
+			if err == nil {
+				c.lastSuccess = time.Now()
+			}
+			c.done = nil
+			c.mu.Unlock()
+			close(done)
+		}()
+	}
+	done := c.done
+	c.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	var (
+		r   = Result{Name: c.checker.Name(), Critical: !c.nonCritical}
+		err error
+	)
+	select {
+	case <-done:
+		c.mu.Lock()
+		err, r.Latency = c.err, c.latency
+		c.mu.Unlock()
+	case <-t.C:
+		err, r.Latency = fmt.Errorf("timeout after %s", timeout), timeout
+	}
+
+	c.mu.Lock()
+	r.LastSuccess = c.lastSuccess
+	c.mu.Unlock()
+
+	r.Status = StatusOK
+	if err != nil {
+		r.Status, r.Error = StatusFail, err.Error()
+	}
+	return r
+}
@@ -2,13 +2,17 @@ package health
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
 
+	"devcode.xeemore.com/systech/gojunkyard/router"
 	"devcode.xeemore.com/systech/gojunkyard/webserver"
 
 	"devcode.xeemore.com/systech/gojunkyard/reporter"
@@ -21,47 +25,108 @@ type Checker interface {
 	Check() error
 }
 
-// Health is object that handle liveness (ping) and readiness (healthz)
+// List of the message served when the probe is not checked yet
+const (
+	msgNotReady   = "Not ready to check. App is trying to up"
+	msgNotStarted = "Not started yet. App is initializing"
+)
+
+// ErrNoServer is returned by Run and RunGraceful of the Health created by NewWithOptions(nil)
+var ErrNoServer = errors.New("health: no server, the probes are served by Mount")
+
+// Health is object that handle liveness (ping and healthz), readiness (readyz) and startup (startupz) probes
 type Health struct {
-	mux      sync.RWMutex
-	checker  []Checker
-	ready    bool
-	server   webserver.Server
-	reporter reporter.Reporter
+	mux            sync.RWMutex
+	checks         []*check
+	timeout        time.Duration
+	ready          bool
+	started        bool
+	requireStartup bool
+	server         webserver.Server
+	reporter       reporter.Reporter
+	threshold      int
+	callbacks      []func(Transition)
+	cached         *Report
+	cachedChecks   []*check
+	pollStop       chan struct{}
+	pollDone       chan struct{}
 }
 
-// New returns Health object
+// New returns Health object served on :54322
 func New() *Health {
+	return NewWithOptions(&webserver.Options{
+		ListenAddress:   ":54322",
+		MaxConnections:  5,
+		ReadTimeout:     5 * time.Second,
+		WriteTimeout:    5 * time.Second,
+		GracefulTimeout: 5 * time.Second,
+	})
+}
+
+// NewWithOptions returns Health object served by the webserver created using options. Nil options creates no
+// webserver to serve the probes only by Mount on the router of the main server, see Mount for the required calls
+func NewWithOptions(options *webserver.Options) *Health {
 	health := &Health{
-		checker:  make([]Checker, 0),
+		checks:   make([]*check, 0),
 		reporter: nop.NewNopReporter(),
-		server: webserver.New(&webserver.Options{
-			ListenAddress:   ":54322",
-			MaxConnections:  5,
-			ReadTimeout:     5 * time.Second,
-			WriteTimeout:    5 * time.Second,
-			GracefulTimeout: 5 * time.Second,
-		}),
-	}
-	health.initrouter()
+	}
+	if options != nil {
+		health.server = webserver.New(options)
+		health.Mount(health.server.Router())
+	}
 	return health
 }
 
-func (h *Health) initrouter() {
-	router := h.server.Router()
-	router.GET("/ping", h.ping)
-	router.GET("/healthz", h.healthz)
+// Mount registers /ping, /healthz, /readyz and /startupz on r. The probes served by Mount or Run fail with 503
+// until the app calls SetReadiness(true) to have healthz and readyz run the checkers, and SetStarted(true) when
+// its initialization is completed to have startupz and readyz pass. RunGraceful does both unless RequireStartup
+// is called
+func (h *Health) Mount(r *router.Router) {
+	r.GET("/ping", h.ping)
+	r.GET("/healthz", h.healthz)
+	r.GET("/readyz", h.readyz)
+	r.GET("/startupz", h.startupz)
 }
 
-// Register ...
+// Register adds the checkers checked by both healthz and readyz. Every checker is critical unless it is wrapped by
+// NonCritical, use WithTimeout to give the checker its own timeout
 func (h *Health) Register(c ...Checker) {
+	h.register(probeLiveness|probeReadiness, c)
+}
+
+// RegisterLiveness adds the checkers checked by healthz only, e.g. the deadlock detector which needs a restart
+func (h *Health) RegisterLiveness(c ...Checker) {
+	h.register(probeLiveness, c)
+}
+
+// RegisterReadiness adds the checkers checked by readyz only, e.g. the dependencies without which
+// the app should not receive the traffic but does not need a restart
+func (h *Health) RegisterReadiness(c ...Checker) {
+	h.register(probeReadiness, c)
+}
+
+func (h *Health) register(p probe, c []Checker) {
+	h.mux.Lock()
+	for _, v := range c {
+		h.checks = append(h.checks, newCheck(v, p))
+	}
+	h.mux.Unlock()
+}
+
+// SetTimeout changes the timeout of every checker which is not registered using WithTimeout, default is DefaultTimeout
+func (h *Health) SetTimeout(timeout time.Duration) {
 	h.mux.Lock()
-	h.checker = append(h.checker, c...)
+	h.timeout = timeout
 	h.mux.Unlock()
 }
 
 // Run ...
 func (h *Health) Run() chan error {
+	if h.server == nil {
+		errc := make(chan error, 1)
+		errc <- ErrNoServer
+		return errc
+	}
 	return h.server.Run()
 }
 
@@ -85,35 +150,83 @@ func (h *Health) GetReadiness() bool {
 	return h.ready
 }
 
+// SetStarted marks the initialization of the app is completed, startupz and readyz fail until it is started
+func (h *Health) SetStarted(started bool) {
+	h.mux.Lock()
+	h.started = started
+	h.mux.Unlock()
+}
+
+// GetStarted ...
+func (h *Health) GetStarted() bool {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	return h.started
+}
+
 // SetReporter ...
 func (h *Health) SetReporter(reporter reporter.Reporter) {
 	h.reporter = reporter
 }
 
-func (h *Health) healthz(w http.ResponseWriter, _ *http.Request) {
-	// condition 1. if app has not set to be ready then return service unavailable
+func (h *Health) healthz(w http.ResponseWriter, r *http.Request) {
+	var msg string
 	if !h.GetReadiness() {
-		const msg = "Not ready to check. App is trying to up"
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte(msg))
-		h.reporter.Warningf("Health: %s\n", msg)
+		msg = msgNotReady
+	}
+	h.serve(w, r, "Health", probeLiveness, msg)
+}
+
+func (h *Health) readyz(w http.ResponseWriter, r *http.Request) {
+	var msg string
+	switch {
+	case !h.GetStarted():
+		msg = msgNotStarted
+	case !h.GetReadiness():
+		msg = msgNotReady
+	}
+	h.serve(w, r, "Readiness", probeReadiness, msg)
+}
+
+func (h *Health) startupz(w http.ResponseWriter, r *http.Request) {
+	if !h.GetStarted() {
+		writeUnavailable(w, wantJSON(r), msgNotStarted)
+		return
+	}
+
+	if wantJSON(r) {
+		writeJSON(w, http.StatusOK, Report{Status: StatusHealthy})
+		return
+	}
+	w.Write([]byte("STARTED"))
+}
+
+// serve writes the report of the checkers of probe p, or service unavailable with unavailable message if it is set
+func (h *Health) serve(w http.ResponseWriter, r *http.Request, name string, p probe, unavailable string) {
+	isJSON := wantJSON(r)
+
+	// condition 1. if app has not started or set to be ready then return service unavailable
+	if unavailable != "" {
+		writeUnavailable(w, isJSON, unavailable)
+		h.reporter.Warningf(name+": %s\n", unavailable)
 		return
 	}
 
 	// condition 2. app health has been ready to be checked
 	var (
-		code = http.StatusOK
-		buff = bytes.NewBuffer(make([]byte, 0, len(h.checker)*20))
+		code           = http.StatusOK
+		report, cached = h.report(p)
+		buff           = bytes.NewBuffer(make([]byte, 0, len(report.Checks)*20))
 	)
 
-	for _, v := range h.checker {
-		err := v.Check()
-		if err == nil {
-			fmt.Fprintf(buff, "%s: [OK]\n", v.Name())
+	for _, v := range report.Checks {
+		if v.Status == StatusOK {
+			fmt.Fprintf(buff, "%s: [OK]\n", v.Name)
 			continue
 		}
-
-		fmt.Fprintf(buff, "%s: [err: %s]\n", v.Name(), err)
+		fmt.Fprintf(buff, "%s: [err: %s]\n", v.Name, v.Error)
+	}
+	if report.Status == StatusUnhealthy {
 		code = http.StatusServiceUnavailable
 	}
 
@@ -126,27 +239,152 @@ func (h *Health) healthz(w http.ResponseWriter, _ *http.Request) {
 	bh.Len = len(byt)
 
 	/* write the response */
-	w.WriteHeader(code)
-	w.Write(byt)
+	if isJSON {
+		writeJSON(w, code, report)
+	} else {
+		w.WriteHeader(code)
+		w.Write(byt)
+	}
 
-	/* log the health */
+	/* log the health, the poller reports the transitions instead */
+	if cached {
+		return
+	}
 	reportf := h.reporter.Infof
-	if code != http.StatusOK {
+	switch report.Status {
+	case StatusDegraded:
+		reportf = h.reporter.Warningf
+	case StatusUnhealthy:
 		reportf = h.reporter.Errorf
 	}
-	reportf("Health: \n%s\n", b)
+	reportf(name+": \n%s\n", b)
+}
+
+// Check runs every checker concurrently, each is bounded by its timeout. The report is unhealthy when any critical
+// checker fails, degraded when only the non-critical checkers fail, otherwise healthy
+func (h *Health) Check() Report {
+	_, report := h.check(probeLiveness | probeReadiness)
+	return report
+}
+
+// report returns the results of the checkers of probe p from the last report of the poller when it is polling,
+// otherwise runs the checkers
+func (h *Health) report(p probe) (Report, bool) {
+	h.mux.RLock()
+	cached, checks := h.cached, h.cachedChecks
+	h.mux.RUnlock()
+	if cached == nil {
+		_, report := h.check(p)
+		return report, false
+	}
+
+	results := make([]Result, 0, len(checks))
+	for i, v := range checks {
+		if v.probes&p != 0 {
+			results = append(results, cached.Checks[i])
+		}
+	}
+	return newReport(results), true
 }
 
-// RunGraceful run the webserver with blocking
+// check runs the checkers of probe p and returns them along with the report having the result of each checker
+// at its index
+func (h *Health) check(p probe) ([]*check, Report) {
+	h.mux.RLock()
+	checks := make([]*check, 0, len(h.checks))
+	for _, v := range h.checks {
+		if v.probes&p != 0 {
+			checks = append(checks, v)
+		}
+	}
+	timeout := h.timeout
+	h.mux.RUnlock()
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	var (
+		results = make([]Result, len(checks))
+		wg      sync.WaitGroup
+	)
+	for i, v := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = c.run(timeout)
+		}(i, v)
+	}
+	wg.Wait()
+	return checks, newReport(results)
+}
+
+// newReport returns the report of results which is unhealthy when any critical checker fails, degraded when only
+// the non-critical checkers fail, otherwise healthy
+func newReport(results []Result) Report {
+	report := Report{Status: StatusHealthy, Checks: results}
+	for _, v := range results {
+		switch {
+		case v.Status == StatusOK:
+		case v.Critical:
+			report.Status = StatusUnhealthy
+		case report.Status == StatusHealthy:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// wantJSON reports whether the client asks for json using "?format=json" or Accept header
+func wantJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeUnavailable(w http.ResponseWriter, isJSON bool, msg string) {
+	if isJSON {
+		writeJSON(w, http.StatusServiceUnavailable, Report{Status: StatusUnhealthy, Error: msg})
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(msg))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// RequireStartup makes RunGraceful leave the app not started, so startupz and readyz fail until the app calls
+// SetStarted(true) after its initialization, e.g. warming up the cache
+func (h *Health) RequireStartup() {
+	h.mux.Lock()
+	h.requireStartup = true
+	h.mux.Unlock()
+}
+
+// RunGraceful run the webserver with blocking. The app is marked ready, and started unless RequireStartup is called
 func (h *Health) RunGraceful() error {
-	h.SetReadiness(true)
+	if h.server == nil {
+		return ErrNoServer
+	}
+
+	h.mux.Lock()
+	h.ready = true
+	if !h.requireStartup {
+		h.started = true
+	}
+	h.mux.Unlock()
 	defer h.SetReadiness(false)
 
 	return h.server.RunGraceful()
 }
 
-// Stop terminate the server gracefully
+// Stop terminate the server gracefully and stops the poller
 func (h *Health) Stop() error {
+	h.StopPolling()
 	if h.server == nil {
 		return nil
 	}
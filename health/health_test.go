@@ -1,12 +1,15 @@
 package health
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"devcode.xeemore.com/systech/gojunkyard/reporter"
+	"devcode.xeemore.com/systech/gojunkyard/router"
 	"devcode.xeemore.com/systech/gojunkyard/webserver"
 
 	"github.com/stretchr/testify/assert"
@@ -165,11 +168,11 @@ func TestHealth_healthz(t *testing.T) {
 			name: "exist error",
 			health: &Health{
 				ready: true,
-				checker: func() []Checker {
+				checks: func() []*check {
 					checker := new(_checker)
 					checker.On("Name").Return("HTTP_CLIENT 127.0.0.1:3000")
 					checker.On("Check").Return(errors.New("Cannot connect to server 127.0.0.1:3000"))
-					return []Checker{checker}
+					return []*check{newCheck(checker, probeLiveness)}
 				}(),
 				reporter: func() reporter.Reporter {
 					reporter := new(_reporter)
@@ -190,12 +193,12 @@ func TestHealth_healthz(t *testing.T) {
 			name: "success",
 			health: &Health{
 				ready: true,
-				checker: func() []Checker {
+				checks: func() []*check {
 					var err error
 					checker := new(_checker)
 					checker.On("Name").Return("HTTP_CLIENT 127.0.0.1:3000")
 					checker.On("Check").Return(err)
-					return []Checker{checker}
+					return []*check{newCheck(checker, probeLiveness)}
 				}(),
 				reporter: func() reporter.Reporter {
 					reporter := new(_reporter)
@@ -254,21 +257,241 @@ func TestHealth_Stop(t *testing.T) {
 	}
 }
 
+// gracefulServer records the probes state of health while RunGraceful is running
+type gracefulServer struct {
+	webserver.Server
+	health         *Health
+	ready, started bool
+}
+
+func (gs *gracefulServer) RunGraceful() error {
+	gs.ready, gs.started = gs.health.GetReadiness(), gs.health.GetStarted()
+	return nil
+}
+
+func TestHealth_RunGraceful(t *testing.T) {
+	health := new(Health)
+	server := &gracefulServer{health: health}
+	health.server = server
+
+	assert.Nil(t, health.RunGraceful())
+	assert.True(t, server.ready)
+	assert.True(t, server.started)
+	assert.False(t, health.GetReadiness())
+
+	// the app marks itself started after its initialization
+	health = new(Health)
+	health.RequireStartup()
+	server = &gracefulServer{health: health}
+	health.server = server
+
+	assert.Nil(t, health.RunGraceful())
+	assert.True(t, server.ready)
+	assert.False(t, server.started)
+}
+
 func TestHealth_Register(t *testing.T) {
 	var (
 		health  = New()
 		checker = new(_checker)
 	)
 
-	assert.Len(t, health.checker, 0)
+	assert.Len(t, health.checks, 0)
 
 	health.Register(checker)
-	assert.Len(t, health.checker, 1)
+	assert.Len(t, health.checks, 1)
 
-	health.Register(checker, checker, checker, checker)
-	assert.Len(t, health.checker, 5)
+	health.Register(checker, checker, checker, WithTimeout(checker, time.Second))
+	assert.Len(t, health.checks, 5)
 
-	for _, v := range health.checker {
-		assert.Equal(t, checker, v)
+	for _, v := range health.checks {
+		assert.Equal(t, checker, v.checker)
 	}
+	assert.Equal(t, time.Second, health.checks[4].timeout)
+}
+
+type funcChecker struct {
+	name  string
+	check func() error
+}
+
+func (c funcChecker) Name() string {
+	return c.name
+}
+
+func (c funcChecker) Check() error {
+	return c.check()
+}
+
+func TestHealth_Check(t *testing.T) {
+	var (
+		health  = New()
+		release = make(chan struct{})
+		calls   = make(chan struct{}, 10)
+	)
+	defer close(release)
+
+	sleep := funcChecker{name: "SLEEP", check: func() error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}}
+	hung := funcChecker{name: "HUNG", check: func() error {
+		calls <- struct{}{}
+		<-release
+		return nil
+	}}
+	health.Register(sleep, sleep, WithTimeout(hung, 20*time.Millisecond))
+
+	start := time.Now()
+	report := health.Check()
+	assert.Less(t, int64(time.Since(start)), int64(180*time.Millisecond))
+	assert.Equal(t, StatusUnhealthy, report.Status)
+	assert.Equal(t, StatusOK, report.Checks[0].Status)
+	assert.False(t, report.Checks[0].LastSuccess.IsZero())
+	assert.Equal(t, Result{Name: "HUNG", Status: StatusFail, Critical: true, Latency: 20 * time.Millisecond, Error: "timeout after 20ms"}, report.Checks[2])
+
+	// the hung check is not called again while it is still running
+	health.Check()
+	assert.Len(t, calls, 1)
+}
+
+func TestHealth_healthzJSON(t *testing.T) {
+	health := New()
+	health.SetReadiness(true)
+	health.SetTimeout(time.Second)
+	health.Register(
+		funcChecker{name: "REDIS", check: func() error { return nil }},
+		funcChecker{name: "MYSQL", check: func() error { return errors.New("connection refused") }},
+	)
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/healthz?format=json", nil),
+		func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+			r.Header.Set("Accept", "application/json")
+			return r
+		}(),
+	} {
+		w := httptest.NewRecorder()
+		health.healthz(w, r)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var report struct {
+			Status string
+			Checks []map[string]interface{}
+		}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, "unhealthy", report.Status)
+		assert.Equal(t, "REDIS", report.Checks[0]["name"])
+		assert.Equal(t, "ok", report.Checks[0]["status"])
+		assert.NotEmpty(t, report.Checks[0]["latency"])
+		assert.NotEmpty(t, report.Checks[0]["lastSuccess"])
+		assert.Equal(t, "connection refused", report.Checks[1]["error"])
+		assert.Nil(t, report.Checks[1]["lastSuccess"])
+	}
+
+	// text stays the default
+	w := httptest.NewRecorder()
+	health.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, "REDIS: [OK]\nMYSQL: [err: connection refused]\n", w.Body.String())
+
+	// not ready
+	health.SetReadiness(false)
+	w = httptest.NewRecorder()
+	health.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz?format=json", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status":"unhealthy","error":"Not ready to check. App is trying to up"}`, w.Body.String())
+}
+
+func TestHealth_healthzDegraded(t *testing.T) {
+	var (
+		ok   = funcChecker{name: "REDIS", check: func() error { return nil }}
+		fail = funcChecker{name: "MMDB", check: func() error { return errors.New("file not found") }}
+	)
+
+	reporter := new(_reporter)
+	reporter.On("Warningf", "Health: \n%s\n", "REDIS: [OK]\nMMDB: [err: file not found]\n").Once()
+	reporter.On("Errorf", "Health: \n%s\n", "REDIS: [OK]\nMMDB: [err: file not found]\nMYSQL: [err: file not found]\n").Once()
+
+	health := New()
+	health.SetReadiness(true)
+	health.SetReporter(reporter)
+	health.Register(ok, NonCritical(WithTimeout(fail, time.Second)))
+
+	report := health.Check()
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.True(t, report.Checks[0].Critical)
+	assert.False(t, report.Checks[1].Critical)
+
+	w := httptest.NewRecorder()
+	health.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	health.Register(funcChecker{name: "MYSQL", check: fail.check})
+	assert.Equal(t, StatusUnhealthy, health.Check().Status)
+
+	w = httptest.NewRecorder()
+	health.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	reporter.AssertExpectations(t)
+}
+
+func TestHealth_Mount(t *testing.T) {
+	var (
+		ok   = funcChecker{name: "REDIS", check: func() error { return nil }}
+		fail = funcChecker{name: "KAFKA", check: func() error { return errors.New("no broker") }}
+	)
+
+	health := NewWithOptions(nil)
+	assert.Equal(t, ErrNoServer, health.RunGraceful())
+	assert.Equal(t, ErrNoServer, <-health.Run())
+	assert.False(t, health.GetReadiness())
+	assert.Nil(t, health.Stop())
+
+	health.Register(ok)
+	health.RegisterReadiness(fail)
+	health.RegisterLiveness(NonCritical(fail))
+
+	r := router.New()
+	health.Mount(r.Group("/health"))
+
+	do := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := do("/health/ping")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "PONG", body)
+
+	// not started yet
+	health.SetReadiness(true)
+	code, body = do("/health/startupz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "Not started yet. App is initializing", body)
+	code, _ = do("/health/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	health.SetStarted(true)
+	code, body = do("/health/startupz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "STARTED", body)
+
+	code, body = do("/health/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "REDIS: [OK]\nKAFKA: [err: no broker]\n", body)
+
+	code, body = do("/health/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "REDIS: [OK]\nKAFKA: [err: no broker]\n", body)
+
+	// the cached report of the poller is filtered by the probe
+	health.poll()
+	code, _ = do("/health/healthz?format=json")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = do("/health/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Len(t, health.Check().Checks, 3)
 }
@@ -0,0 +1,134 @@
+package health
+
+import (
+	"time"
+)
+
+// DefaultFlapThreshold is the number of consecutive results required to change the state of a checker,
+// see Health.SetFlapThreshold
+const DefaultFlapThreshold = 3
+
+// Transition is the state change of a checker detected by the poller, e.g. from StatusOK to StatusFail
+type Transition struct {
+	From   Status
+	To     Status
+	Result Result
+}
+
+// SetFlapThreshold changes the number of consecutive results with the new status required before the poller
+// changes the state of a checker, so the flapping checker does not fire a transition on every poll.
+// Default is DefaultFlapThreshold
+func (h *Health) SetFlapThreshold(threshold int) {
+	h.mux.Lock()
+	h.threshold = threshold
+	h.mux.Unlock()
+}
+
+// OnTransition registers f called by the poller when a checker changes its state
+func (h *Health) OnTransition(f func(Transition)) {
+	h.mux.Lock()
+	h.callbacks = append(h.callbacks, f)
+	h.mux.Unlock()
+}
+
+// StartPolling runs every checker each interval in the background. healthz serves the last report of the poller
+// instead of running the checkers on every request, and the reporter is only notified on transitions.
+// Calling it when the poller is running does nothing
+func (h *Health) StartPolling(interval time.Duration) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.pollStop != nil {
+		return
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	h.pollStop, h.pollDone = stop, done
+	go func() {
+		defer close(done)
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			h.poll()
+			select {
+			case <-t.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopPolling stops the poller and waits for the running poll. healthz runs the checkers on every request again
+func (h *Health) StopPolling() {
+	h.mux.Lock()
+	stop, done := h.pollStop, h.pollDone
+	h.pollStop, h.pollDone = nil, nil
+	h.mux.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	h.mux.Lock()
+	h.cached, h.cachedChecks = nil, nil
+	h.mux.Unlock()
+}
+
+// poll runs the checkers, caches the report and notifies the transitions
+func (h *Health) poll() {
+	checks, report := h.check(probeLiveness | probeReadiness)
+
+	h.mux.Lock()
+	h.cached, h.cachedChecks = &report, checks
+	threshold, callbacks := h.threshold, h.callbacks
+	h.mux.Unlock()
+	if threshold <= 0 {
+		threshold = DefaultFlapThreshold
+	}
+
+	for i, r := range report.Checks {
+		from, changed := checks[i].observe(r, threshold)
+		if !changed {
+			continue
+		}
+
+		t := Transition{From: from, To: r.Status, Result: r}
+		switch {
+		case t.To == StatusOK:
+			h.reporter.Infof("Health: %s is recovered\n", r.Name)
+		case r.Critical:
+			h.reporter.Errorf("Health: %s is failing. err: %s\n", r.Name, r.Error)
+		default:
+			h.reporter.Warningf("Health: %s is failing. err: %s\n", r.Name, r.Error)
+		}
+		for _, f := range callbacks {
+			f(t)
+		}
+	}
+}
+
+// observe updates the state of the check by r. The state is changed after threshold consecutive results having
+// the other status, it returns the previous state when the state is changed. The initial state is StatusOK
+func (c *check) observe(r Result, threshold int) (Status, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == "" {
+		c.state = StatusOK
+	}
+	if r.Status == c.state {
+		c.streak = 0
+		return c.state, false
+	}
+
+	c.streak++
+	if c.streak < threshold {
+		return c.state, false
+	}
+
+	from := c.state
+	c.state, c.streak = r.Status, 0
+	return from, true
+}
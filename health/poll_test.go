@@ -0,0 +1,112 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHealth_poll(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		err error
+	)
+	setErr := func(e error) {
+		mu.Lock()
+		err = e
+		mu.Unlock()
+	}
+	redis := funcChecker{name: "REDIS", check: func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return err
+	}}
+
+	reporter := new(_reporter)
+	reporter.On("Errorf", "Health: %s is failing. err: %s\n", "REDIS", "connection refused").Once()
+	reporter.On("Infof", "Health: %s is recovered\n", "REDIS").Once()
+
+	var transitions []Transition
+	health := New()
+	health.SetReporter(reporter)
+	health.SetFlapThreshold(2)
+	health.OnTransition(func(t Transition) {
+		transitions = append(transitions, t)
+	})
+	health.Register(redis)
+
+	for _, e := range []error{
+		nil,
+		errors.New("connection refused"), // flap, suppressed
+		nil,
+		errors.New("connection refused"),
+		errors.New("connection refused"), // OK -> FAIL
+		errors.New("connection refused"),
+		nil,
+		nil, // FAIL -> OK
+	} {
+		setErr(e)
+		health.poll()
+	}
+
+	assert.Len(t, transitions, 2)
+	assert.Equal(t, StatusOK, transitions[0].From)
+	assert.Equal(t, StatusFail, transitions[0].To)
+	assert.Equal(t, "connection refused", transitions[0].Result.Error)
+	assert.Equal(t, StatusFail, transitions[1].From)
+	assert.Equal(t, StatusOK, transitions[1].To)
+	reporter.AssertExpectations(t)
+}
+
+func TestHealth_StartPolling(t *testing.T) {
+	var (
+		calls   = make(chan struct{}, 100)
+		checker = funcChecker{name: "MMDB", check: func() error {
+			calls <- struct{}{}
+			return errors.New("file not found")
+		}}
+	)
+
+	reporter := new(_reporter)
+	reporter.On("Warningf", "Health: %s is failing. err: %s\n", "MMDB", "file not found").Once()
+
+	health := New()
+	health.SetReadiness(true)
+	health.SetReporter(reporter)
+	health.SetFlapThreshold(1)
+	health.Register(NonCritical(checker))
+
+	health.StartPolling(10 * time.Millisecond)
+	health.StartPolling(10 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return len(calls) >= 3
+	}, time.Second, 5*time.Millisecond)
+
+	// healthz serves the cached report without running the checker and reporting it
+	health.StopPolling()
+	n := len(calls)
+	health.mux.Lock()
+	health.cached = &Report{Status: StatusDegraded, Checks: []Result{{Name: "MMDB", Status: StatusFail, Error: "file not found"}}}
+	health.cachedChecks = health.checks
+	health.mux.Unlock()
+
+	w := httptest.NewRecorder()
+	health.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MMDB: [err: file not found]\n", w.Body.String())
+	assert.Equal(t, n, len(calls))
+	reporter.AssertExpectations(t)
+
+	// stopping the poller drops the cached report
+	health.StopPolling()
+	reporter.On("Warningf", "Health: \n%s\n", mock.Anything).Once()
+	health.healthz(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, n+1, len(calls))
+	assert.Nil(t, health.Stop())
+}
@@ -24,6 +24,11 @@ func New() func(next http.Handler) http.Handler {
 	}
 }
 
+// NewContext returns the copy of ctx carrying id, e.g. the request id received from the message broker
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxK{}, id)
+}
+
 // GetFromRequest ...
 func GetFromRequest(r *http.Request) string {
 	if r == nil {
@@ -1,6 +1,7 @@
 package requestid
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -47,3 +48,8 @@ func TestNew(t *testing.T) {
 		assert.Equal(t, expected, w.Header().Get(header))
 	}
 }
+
+func TestNewContext(t *testing.T) {
+	ctx := NewContext(context.Background(), "0685d19c7a3741f09369271af0750eed")
+	assert.Equal(t, "0685d19c7a3741f09369271af0750eed", GetFromContext(ctx))
+}
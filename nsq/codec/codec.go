@@ -0,0 +1,154 @@
+package nsqcodec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec is used to encode the published data and decode the consumed message body
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Raw is the already encoded message body. The producer publishes it as is regardless of the codec of the topic
+type Raw []byte
+
+// List of built-in codec
+var (
+	JSON     Codec = jsonCodec{}
+	Protobuf Codec = protobufCodec{}
+	Msgpack  Codec = msgpackCodec{}
+	GzipJSON Codec = NewGzip(JSON)
+)
+
+var (
+	mu     sync.RWMutex
+	codecs = map[string]Codec{
+		JSON.Name():     JSON,
+		Protobuf.Name(): Protobuf,
+		Msgpack.Name():  Msgpack,
+		GzipJSON.Name(): GzipJSON,
+	}
+)
+
+// Register makes the codec available by its name, it replaces the codec which has the same name
+func Register(c Codec) {
+	mu.Lock()
+	codecs[c.Name()] = c
+	mu.Unlock()
+}
+
+// Get returns the codec registered by name. Empty name returns JSON codec
+func Get(name string) (Codec, error) {
+	if name == "" {
+		return JSON, nil
+	}
+
+	mu.RLock()
+	c, ok := codecs[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("[NSQ] codec %q is not registered", name)
+	}
+	return c, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// protobufCodec accepts both the messages generated by google.golang.org/protobuf, e.g. the grpc services,
+// and the older generated messages implementing proto.Message
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("[NSQ] protobuf codec cannot marshal %T, it must implement proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("[NSQ] protobuf codec cannot unmarshal into %T, it must implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// msgpackCodec uses the json struct tag, so the same struct can be used with json codec
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+type gzipCodec struct {
+	codec Codec
+}
+
+// NewGzip returns codec which compresses the output of c using gzip, the name is "gzip+<c.Name()>"
+func NewGzip(c Codec) Codec {
+	return gzipCodec{codec: c}
+}
+
+func (g gzipCodec) Name() string { return "gzip+" + g.codec.Name() }
+
+func (g gzipCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := g.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g gzipCodec) Unmarshal(data []byte, v interface{}) error {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return g.codec.Unmarshal(b, v)
+}
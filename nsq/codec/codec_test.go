@@ -0,0 +1,68 @@
+package nsqcodec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type payload struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestGet(t *testing.T) {
+	c, err := Get("")
+	assert.Nil(t, err)
+	assert.Equal(t, JSON, c)
+
+	for _, name := range []string{"json", "protobuf", "msgpack", "gzip+json"} {
+		c, err = Get(name)
+		assert.Nil(t, err)
+		assert.Equal(t, name, c.Name())
+	}
+
+	_, err = Get("xml")
+	assert.NotNil(t, err)
+
+	Register(NewGzip(Msgpack))
+	c, err = Get("gzip+msgpack")
+	assert.Nil(t, err)
+	assert.Equal(t, NewGzip(Msgpack), c)
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSON, Msgpack, GzipJSON, NewGzip(Msgpack)} {
+		t.Run(c.Name(), func(t *testing.T) {
+			b, err := c.Marshal(&payload{ID: 1, Name: "gojunkyard"})
+			assert.Nil(t, err)
+
+			var got payload
+			assert.Nil(t, c.Unmarshal(b, &got))
+			assert.Equal(t, payload{ID: 1, Name: "gojunkyard"}, got)
+		})
+	}
+}
+
+func TestCodec_Protobuf(t *testing.T) {
+	b, err := Protobuf.Marshal(wrapperspb.String("gojunkyard"))
+	assert.Nil(t, err)
+
+	var got wrapperspb.StringValue
+	assert.Nil(t, Protobuf.Unmarshal(b, &got))
+	assert.Equal(t, "gojunkyard", got.Value)
+
+	// the message shared with the grpc services
+	b, err = Protobuf.Marshal(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+	assert.Nil(t, err)
+
+	var res healthpb.HealthCheckResponse
+	assert.Nil(t, Protobuf.Unmarshal(b, &res))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+
+	_, err = Protobuf.Marshal(&payload{})
+	assert.NotNil(t, err)
+	assert.NotNil(t, Protobuf.Unmarshal(b, &payload{}))
+}
@@ -0,0 +1,188 @@
+package nsqconsumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	gjerrors "devcode.xeemore.com/systech/gojunkyard/errors"
+	"devcode.xeemore.com/systech/gojunkyard/http/httpresponse"
+	"devcode.xeemore.com/systech/gojunkyard/router"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// ErrSubscriptionNotFound is returned when there is no running consumer for the topic and channel
+var ErrSubscriptionNotFound = errors.New("[NSQ] subscription is not found")
+
+// subscription is the running nsq consumer of one topic and channel
+type subscription struct {
+	topic       string
+	channel     string
+	maxInFlight int
+	paused      bool
+	consumer    *nsq.Consumer
+	config      *ConsumerConfig
+	topicConfig Topic
+	handler     nsq.Handler
+	cancel      context.CancelFunc
+}
+
+// stop stops receiving the message and waits for the in-flight messages before releasing the handler
+func (s *subscription) stop() {
+	s.consumer.Stop()
+	<-s.consumer.StopChan
+	s.close()
+}
+
+// close cancels the context passed to the handler and releases the resources held by the handler,
+// e.g. the batch pipeliner and the ordered lanes. It must be called after the nsq consumer is stopped
+func (s *subscription) close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if h, ok := s.handler.(interface{ close() }); ok {
+		h.close()
+	}
+}
+
+// Stats is the state of a running consumer taken from nsq.Consumer.Stats
+type Stats struct {
+	Topic            string `json:"topic"`
+	Channel          string `json:"channel"`
+	MaxInFlight      int    `json:"maxInFlight"`
+	Paused           bool   `json:"paused"`
+	MessagesReceived uint64 `json:"messagesReceived"`
+	MessagesFinished uint64 `json:"messagesFinished"`
+	MessagesRequeued uint64 `json:"messagesRequeued"`
+	Connections      int    `json:"connections"`
+}
+
+// Stats returns the state of every running consumer
+func (c *Consumer) Stats() []Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := make([]Stats, 0, len(c.consumers))
+	for _, v := range c.consumers {
+		s := v.consumer.Stats()
+		stats = append(stats, Stats{
+			Topic:            v.topic,
+			Channel:          v.channel,
+			MaxInFlight:      v.maxInFlight,
+			Paused:           v.paused,
+			MessagesReceived: s.MessagesReceived,
+			MessagesFinished: s.MessagesFinished,
+			MessagesRequeued: s.MessagesRequeued,
+			Connections:      s.Connections,
+		})
+	}
+	return stats
+}
+
+// Pause stops receiving new message for the channel. Empty topic pauses every topic of the channel
+func (c *Consumer) Pause(topic, channel string) error {
+	return c.each(topic, channel, func(sub *subscription) {
+		sub.paused = true
+		sub.consumer.ChangeMaxInFlight(0)
+	})
+}
+
+// Resume continues receiving message for the paused channel. Empty topic resumes every topic of the channel
+func (c *Consumer) Resume(topic, channel string) error {
+	return c.each(topic, channel, func(sub *subscription) {
+		sub.paused = false
+		sub.consumer.ChangeMaxInFlight(sub.maxInFlight)
+	})
+}
+
+// ChangeMaxInFlight changes the max in flight of the channel. Empty topic changes every topic of the channel.
+// The paused channel keeps paused and uses the new max in flight when it is resumed
+func (c *Consumer) ChangeMaxInFlight(topic, channel string, maxInFlight int) error {
+	if maxInFlight < 0 {
+		return fmt.Errorf("[NSQ] invalid max in flight: %d", maxInFlight)
+	}
+	return c.each(topic, channel, func(sub *subscription) {
+		sub.maxInFlight = maxInFlight
+		if !sub.paused {
+			sub.consumer.ChangeMaxInFlight(maxInFlight)
+		}
+	})
+}
+
+// each calls f for every subscription matching topic and channel
+func (c *Consumer) each(topic, channel string, f func(*subscription)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found bool
+	for _, v := range c.consumers {
+		if v.channel != channel || (topic != "" && v.topic != topic) {
+			continue
+		}
+		f(v)
+		found = true
+	}
+	if !found {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+// RegisterAdminRoutes mounts the admin API on r
+// GET  /consumers                              returns Stats of every consumer
+// POST /consumers/:channel/pause?topic=         pauses the channel
+// POST /consumers/:channel/resume?topic=        resumes the channel
+// PUT  /consumers/:channel/max-in-flight?topic= changes the max in flight using body {"maxInFlight": 10}
+func (c *Consumer) RegisterAdminRoutes(r *router.Router) {
+	r.GET("/consumers", c.adminStats)
+	r.POST("/consumers/:channel/pause", c.adminPause)
+	r.POST("/consumers/:channel/resume", c.adminResume)
+	r.PUT("/consumers/:channel/max-in-flight", c.adminChangeMaxInFlight)
+}
+
+func (c *Consumer) adminStats(w http.ResponseWriter, r *http.Request) {
+	httpresponse.WithData(w, c.Stats())
+}
+
+func (c *Consumer) adminPause(w http.ResponseWriter, r *http.Request) {
+	err := c.Pause(r.URL.Query().Get("topic"), router.GetParam(r, "channel"))
+	c.adminRespond(w, err)
+}
+
+func (c *Consumer) adminResume(w http.ResponseWriter, r *http.Request) {
+	err := c.Resume(r.URL.Query().Get("topic"), router.GetParam(r, "channel"))
+	c.adminRespond(w, err)
+}
+
+func (c *Consumer) adminChangeMaxInFlight(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MaxInFlight *int `json:"maxInFlight"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.MaxInFlight == nil {
+		httpresponse.WithError(w, http.StatusBadRequest, gjerrors.New(
+			http.StatusBadRequest, "NSQ001", "Bad Request", "maxInFlight is required",
+		))
+		return
+	}
+
+	err := c.ChangeMaxInFlight(r.URL.Query().Get("topic"), router.GetParam(r, "channel"), *body.MaxInFlight)
+	c.adminRespond(w, err)
+}
+
+func (c *Consumer) adminRespond(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		httpresponse.WithData(w, c.Stats())
+	case err == ErrSubscriptionNotFound:
+		httpresponse.WithError(w, http.StatusNotFound, gjerrors.New(
+			http.StatusNotFound, "NSQ002", "Not Found", err.Error(),
+		))
+	default:
+		httpresponse.WithError(w, http.StatusBadRequest, gjerrors.New(
+			http.StatusBadRequest, "NSQ001", "Bad Request", err.Error(),
+		))
+	}
+}
@@ -0,0 +1,95 @@
+package nsqconsumer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"devcode.xeemore.com/systech/gojunkyard/router"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newAdminTestConsumer() *Consumer {
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(&ConsumerConfig{
+		Name:        "HARNESS_CHANNEL",
+		Topics:      []Topic{{Name: "HARNESS_TOPIC_1"}, {Name: "HARNESS_TOPIC_2"}},
+		MaxInFlight: 10,
+		Concurrency: 1,
+	})
+
+	consumer := NewConsumer(cfg)
+	consumer.RegisterHandler(new(harnessHandler))
+	consumer.init()
+	return consumer
+}
+
+func TestConsumer_Admin(t *testing.T) {
+	consumer := newAdminTestConsumer()
+	defer consumer.Stop()
+
+	stats := consumer.Stats()
+	assert.Len(t, stats, 2)
+	assert.Equal(t, Stats{Topic: "HARNESS_TOPIC_1", Channel: "HARNESS_CHANNEL", MaxInFlight: 10}, stats[0])
+
+	assert.Equal(t, ErrSubscriptionNotFound, consumer.Pause("", "UNKNOWN"))
+	assert.Equal(t, ErrSubscriptionNotFound, consumer.Pause("UNKNOWN", "HARNESS_CHANNEL"))
+
+	assert.Nil(t, consumer.Pause("HARNESS_TOPIC_1", "HARNESS_CHANNEL"))
+	assert.Nil(t, consumer.ChangeMaxInFlight("", "HARNESS_CHANNEL", 20))
+	assert.NotNil(t, consumer.ChangeMaxInFlight("", "HARNESS_CHANNEL", -1))
+
+	stats = consumer.Stats()
+	assert.True(t, stats[0].Paused)
+	assert.Equal(t, 20, stats[0].MaxInFlight)
+	assert.False(t, stats[1].Paused)
+	assert.Equal(t, 20, stats[1].MaxInFlight)
+
+	assert.Nil(t, consumer.Resume("", "HARNESS_CHANNEL"))
+	assert.False(t, consumer.Stats()[0].Paused)
+}
+
+func TestConsumer_RegisterAdminRoutes(t *testing.T) {
+	consumer := newAdminTestConsumer()
+	defer consumer.Stop()
+
+	r := router.New()
+	consumer.RegisterAdminRoutes(r.Group("/admin"))
+
+	do := func(method, path, body string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, resp := do(http.MethodGet, "/admin/consumers", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, resp["data"], 2)
+
+	code, _ = do(http.MethodPost, "/admin/consumers/HARNESS_CHANNEL/pause?topic=HARNESS_TOPIC_2", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, consumer.Stats()[1].Paused)
+
+	code, _ = do(http.MethodPost, "/admin/consumers/HARNESS_CHANNEL/resume", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, consumer.Stats()[1].Paused)
+
+	code, _ = do(http.MethodPost, "/admin/consumers/UNKNOWN/pause", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = do(http.MethodPut, "/admin/consumers/HARNESS_CHANNEL/max-in-flight", `{"maxInFlight": 3}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, consumer.Stats()[0].MaxInFlight)
+
+	code, _ = do(http.MethodPut, "/admin/consumers/HARNESS_CHANNEL/max-in-flight", `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = do(http.MethodPut, "/admin/consumers/HARNESS_CHANNEL/max-in-flight", `{"maxInFlight": -1}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
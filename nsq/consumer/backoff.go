@@ -0,0 +1,134 @@
+package nsqconsumer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// List of backoff strategy
+const (
+	BackoffFixed       = "fixed"
+	BackoffLinear      = "linear"
+	BackoffExponential = "exponential"
+)
+
+// defaultRequeueDelay is used when the consumer has no backoff configuration
+const defaultRequeueDelay = time.Second
+
+// Duration is time.Duration which can be decoded from JSON string such as "1.5s" or number of nanosecond
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as string, e.g. "1m30s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes the duration from string or number of nanosecond
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v)
+		return nil
+	case string:
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(dur)
+		return nil
+	default:
+		return fmt.Errorf("invalid duration: %s", b)
+	}
+}
+
+// Backoff holds the requeue delay policy of a consumer
+// Strategy: "fixed" (default) always uses Delay, "linear" uses Delay * attempts, "exponential" uses Delay * 2^(attempts-1)
+// Delay: base delay of the strategy, default is 1 second
+// Max: cap of the delay, ignored if zero
+// Jitter: fraction [0, 1] of the delay which is randomly subtracted, so requeued messages are not retried at the same time
+type Backoff struct {
+	Strategy string   `json:"strategy"`
+	Delay    Duration `json:"delay"`
+	Max      Duration `json:"max"`
+	Jitter   float64  `json:"jitter"`
+}
+
+// Duration returns the requeue delay for the message which has been attempted attempts times
+func (b *Backoff) Duration(attempts uint16) time.Duration {
+	if b == nil {
+		return defaultRequeueDelay
+	}
+
+	base := time.Duration(b.Delay)
+	if base <= 0 {
+		base = defaultRequeueDelay
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var d float64
+	switch b.Strategy {
+	case BackoffLinear:
+		d = float64(base) * float64(attempts)
+	case BackoffExponential:
+		d = float64(base) * math.Pow(2, float64(attempts-1))
+	default:
+		d = float64(base)
+	}
+
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d -= d * math.Min(b.Jitter, 1) * rand.Float64()
+	}
+	// the exponential delay without Max overflows time.Duration after enough attempts
+	if d >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// RequeueError is the error returned by handler to requeue the message with its own delay.
+// The message is requeued even though the handler returns false
+type RequeueError struct {
+	Delay time.Duration
+	Err   error
+}
+
+// RequeueAfter wraps err into RequeueError so the message is requeued after delay
+func RequeueAfter(delay time.Duration, err error) error {
+	return &RequeueError{Delay: delay, Err: err}
+}
+
+// Error implements error interface
+func (e *RequeueError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("requeue after %s", e.Delay)
+	}
+	return fmt.Sprintf("requeue after %s: %s", e.Delay, e.Err)
+}
+
+// Unwrap returns the wrapped error
+func (e *RequeueError) Unwrap() error {
+	return e.Err
+}
+
+// requeueDelay returns the delay carried by RequeueError inside err
+func requeueDelay(err error) (time.Duration, bool) {
+	var re *RequeueError
+	if errors.As(err, &re) {
+		return re.Delay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,72 @@
+package nsqconsumer
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	var d Duration
+	assert.Nil(t, json.Unmarshal([]byte(`"1m30s"`), &d))
+	assert.Equal(t, Duration(90*time.Second), d)
+
+	assert.Nil(t, json.Unmarshal([]byte(`1000`), &d))
+	assert.Equal(t, Duration(time.Microsecond), d)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`"abc"`), &d))
+	assert.NotNil(t, json.Unmarshal([]byte(`true`), &d))
+
+	b, err := json.Marshal(Duration(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, `"1s"`, string(b))
+}
+
+func TestBackoff_Duration(t *testing.T) {
+	var nilBackoff *Backoff
+	assert.Equal(t, time.Second, nilBackoff.Duration(3))
+
+	fixed := &Backoff{Strategy: BackoffFixed, Delay: Duration(2 * time.Second)}
+	assert.Equal(t, 2*time.Second, fixed.Duration(1))
+	assert.Equal(t, 2*time.Second, fixed.Duration(4))
+
+	linear := &Backoff{Strategy: BackoffLinear}
+	assert.Equal(t, time.Second, linear.Duration(0))
+	assert.Equal(t, 3*time.Second, linear.Duration(3))
+
+	exponential := &Backoff{Strategy: BackoffExponential, Delay: Duration(time.Second), Max: Duration(10 * time.Second)}
+	assert.Equal(t, time.Second, exponential.Duration(1))
+	assert.Equal(t, 4*time.Second, exponential.Duration(3))
+	assert.Equal(t, 10*time.Second, exponential.Duration(10))
+
+	unbounded := &Backoff{Strategy: BackoffExponential, Delay: Duration(time.Second)}
+	assert.Equal(t, time.Duration(math.MaxInt64), unbounded.Duration(100))
+	assert.Equal(t, time.Duration(math.MaxInt64), unbounded.Duration(math.MaxUint16))
+	assert.True(t, unbounded.Duration(30) > 0)
+
+	jitter := &Backoff{Strategy: BackoffExponential, Delay: Duration(time.Second), Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		d := jitter.Duration(2)
+		assert.True(t, d > time.Second && d <= 2*time.Second, "jittered delay must be within (1s, 2s]")
+	}
+}
+
+func TestRequeueAfter(t *testing.T) {
+	err := RequeueAfter(time.Minute, errors.New("DOWNSTREAM_DOWN"))
+	assert.Equal(t, "requeue after 1m0s: DOWNSTREAM_DOWN", err.Error())
+	assert.Equal(t, errors.New("DOWNSTREAM_DOWN"), errors.Unwrap(err))
+	assert.Equal(t, "requeue after 1s", RequeueAfter(time.Second, nil).Error())
+
+	delay, ok := requeueDelay(err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, delay)
+
+	_, ok = requeueDelay(errors.New("OTHER"))
+	assert.False(t, ok)
+	_, ok = requeueDelay(nil)
+	assert.False(t, ok)
+}
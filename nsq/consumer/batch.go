@@ -0,0 +1,165 @@
+package nsqconsumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	pipeliner "devcode.xeemore.com/systech/gojunkyard/pipeliner"
+)
+
+const (
+	// DefaultBatchSize is the max number of messages passed to HandleBatch when ConsumerConfig.BatchSize is not set
+	DefaultBatchSize = 100
+	// DefaultBatchWindow is the max time the batch waits for more messages when ConsumerConfig.BatchWindow is not set
+	DefaultBatchWindow = 100 * time.Millisecond
+)
+
+// ErrBatchResultMismatch is returned for every message of the batch when HandleBatch returns
+// the decisions whose length differs from the number of messages
+var ErrBatchResultMismatch = errors.New("[NSQ] HandleBatch returned mismatched decisions length")
+
+// isBatchHandler returns true when h implements HandleBatch instead of Handle
+func isBatchHandler(h Handler) bool {
+	v := reflect.ValueOf(h)
+	return !v.MethodByName("Handle").IsValid() && v.MethodByName("HandleBatch").IsValid()
+}
+
+func (cc *ConsumerConfig) batchSize() int {
+	if cc.BatchSize > 0 {
+		return cc.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+func (cc *ConsumerConfig) batchWindow() time.Duration {
+	if cc.BatchWindow > 0 {
+		return time.Duration(cc.BatchWindow)
+	}
+	return DefaultBatchWindow
+}
+
+// batchConcurrency returns the number of nsq handlers of the batch handler.
+// Every handler waits for the batch of its message, so there must be at least BatchSize handlers to fill the batch
+func (cc *ConsumerConfig) batchConcurrency() int {
+	if cc.Concurrency > cc.batchSize() {
+		return cc.Concurrency
+	}
+	return cc.batchSize()
+}
+
+// handlerConcurrency returns the number of nsq handlers of h. The ordered handler dispatches the messages
+// from a single nsq handler to Concurrency lanes
+func (cc *ConsumerConfig) handlerConcurrency(h Handler) int {
+	switch {
+	case isBatchHandler(h):
+		return cc.batchConcurrency()
+	case cc.OrderingKey != "":
+		return 1
+	}
+	return cc.Concurrency
+}
+
+// batchItem is the message queued to the batch with the context it is handled within
+type batchItem struct {
+	ctx context.Context
+	msg *Message
+}
+
+// newBatchHandler builds the MessageHandler which collects the messages of topic using pipeliner.Pipeliner and
+// passes them to HandleBatch (val). Every message gets its own requeue decision from HandleBatch.
+// HandleBatch is called within the context of the first message of the batch, so it carries the cancellation,
+// the timeout, the MessageMeta, the request id and the span of that message.
+// The returned close flushes and closes the pipeliner, it is called after nsq.Consumer is stopped
+func (c *Consumer) newBatchHandler(cc *ConsumerConfig, topic Topic, val reflect.Value) (MessageHandler, func()) {
+	var (
+		sliceType   = val.Type().In(2)
+		timeout     = time.Duration(cc.Timeout)
+		concurrency = cc.batchConcurrency() / cc.batchSize()
+	)
+
+	p := pipeliner.New(func(items []*batchItem) ([]bool, []error) {
+		var (
+			msgs     = make([]*Message, len(items))
+			requeues = make([]bool, len(items))
+			errs     = make([]error, len(items))
+		)
+		for i, item := range items {
+			msgs[i] = item.msg
+		}
+
+		decisions, err := c.callBatch(items[0].ctx, timeout, val, sliceType, topic, msgs)
+		switch {
+		case decisions == nil && err == nil:
+		case decisions == nil:
+			for i := range msgs {
+				requeues[i], errs[i] = true, err
+			}
+		case len(decisions) != len(msgs):
+			c.reporter.Errorf(
+				"[NSQ] Consumer received mismatched batch decisions. topic: %s, channel: %s, messages: %d, decisions: %d",
+				topic, cc.Name, len(msgs), len(decisions),
+			)
+			for i := range msgs {
+				requeues[i], errs[i] = true, ErrBatchResultMismatch
+			}
+		default:
+			for i, requeue := range decisions {
+				requeues[i], errs[i] = requeue, err
+			}
+		}
+		return requeues, errs
+	}, pipeliner.SetWindow(cc.batchWindow(), cc.batchSize()), pipeliner.SetConcurrency(concurrency))
+
+	handle := func(ctx context.Context, msg *Message) (bool, error) {
+		res, err := p.DoResult(&batchItem{ctx: ctx, msg: msg})
+		requeue, ok := res.(bool)
+		if !ok {
+			return true, err
+		}
+		return requeue, err
+	}
+	return handle, func() {
+		p.Close(context.Background())
+	}
+}
+
+// callBatch calls HandleBatch with the payload of msgs. The panic is reported and returned as error
+func (c *Consumer) callBatch(
+	ctx context.Context,
+	timeout time.Duration,
+	val reflect.Value,
+	sliceType reflect.Type,
+	topic Topic,
+	msgs []*Message,
+) (decisions []bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.reporter.ReportPanic(r, debug.Stack())
+			decisions, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	in := reflect.MakeSlice(sliceType, 0, len(msgs))
+	for _, msg := range msgs {
+		in = reflect.Append(in, reflect.ValueOf(msg.Payload))
+	}
+
+	ret := val.Call([]reflect.Value{
+		reflect.ValueOf(ctx),
+		reflect.ValueOf(topic.Tags),
+		in,
+	})
+	decisions, _ = ret[0].Interface().([]bool)
+	err, _ = ret[1].Interface().(error)
+	return decisions, err
+}
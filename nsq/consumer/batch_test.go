@@ -0,0 +1,181 @@
+package nsqconsumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type batchHandler struct {
+	mu      sync.Mutex
+	batches [][]int64
+	metas   []MessageMeta
+}
+
+func (bh *batchHandler) Name() string {
+	return "BATCH_CHANNEL"
+}
+
+func (bh *batchHandler) HandleBatch(ctx context.Context, tags map[string]interface{}, in []*harnessPayload) ([]bool, error) {
+	ids := make([]int64, 0, len(in))
+	for _, v := range in {
+		ids = append(ids, v.ID)
+	}
+	meta, _ := GetMessageMeta(ctx)
+	bh.mu.Lock()
+	bh.batches = append(bh.batches, ids)
+	bh.metas = append(bh.metas, meta)
+	bh.mu.Unlock()
+
+	requeues := make([]bool, len(in))
+	for i, v := range in {
+		switch v.Mode {
+		case "panic":
+			panic("BATCH_PANIC")
+		case "mismatch":
+			return requeues[:i], nil
+		case "fail":
+			return nil, errors.New("BATCH_FAIL")
+		case "retry":
+			requeues[i] = true
+		}
+	}
+	return requeues, errors.New("BATCH_RETRY")
+}
+
+func newBatchHarness(size int) (*Harness, *batchHandler) {
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(&ConsumerConfig{
+		Name:        "BATCH_CHANNEL",
+		Topics:      []Topic{{Name: "BATCH_TOPIC"}},
+		BatchSize:   size,
+		BatchWindow: Duration(time.Second),
+	})
+
+	bh := new(batchHandler)
+	consumer := NewConsumer(cfg)
+	consumer.RegisterHandler(bh)
+	return NewHarness(consumer), bh
+}
+
+func batchBodies(modes ...string) [][]byte {
+	bodies := make([][]byte, 0, len(modes))
+	for i, mode := range modes {
+		bodies = append(bodies, []byte(fmt.Sprintf(`{"id": %d, "mode": %q}`, i+1, mode)))
+	}
+	return bodies
+}
+
+func TestConsumer_RegisterBatchHandler(t *testing.T) {
+	consumer := NewConsumer(NewConfig(nil, nil))
+	assert.NotPanics(t, func() { consumer.RegisterHandler(new(batchHandler)) })
+	assert.True(t, isBatchHandler(new(batchHandler)))
+	assert.False(t, isBatchHandler(new(harnessHandler)))
+}
+
+func TestConsumerConfig_batch(t *testing.T) {
+	cc := &ConsumerConfig{}
+	assert.Equal(t, DefaultBatchSize, cc.batchSize())
+	assert.Equal(t, DefaultBatchWindow, cc.batchWindow())
+	assert.Equal(t, DefaultBatchSize, cc.batchConcurrency())
+
+	cc = &ConsumerConfig{BatchSize: 10, BatchWindow: Duration(time.Second), Concurrency: 30}
+	assert.Equal(t, 10, cc.batchSize())
+	assert.Equal(t, time.Second, cc.batchWindow())
+	assert.Equal(t, 30, cc.batchConcurrency())
+}
+
+func TestHarness_Batch(t *testing.T) {
+	t.Run("PerMessageDecision", func(t *testing.T) {
+		h, bh := newBatchHarness(3)
+		defer h.Close()
+
+		deliveries := h.PublishConcurrently("BATCH_TOPIC", batchBodies("ok", "retry", "ok")...)
+		assert.Len(t, bh.batches, 1)
+		assert.ElementsMatch(t, []int64{1, 2, 3}, bh.batches[0])
+
+		assert.True(t, deliveries[0][0].Finished)
+		assert.True(t, deliveries[1][0].Requeued)
+		assert.EqualError(t, deliveries[1][0].Err, "BATCH_RETRY")
+		assert.True(t, deliveries[2][0].Finished)
+	})
+
+	t.Run("ErrorWithoutRequeue", func(t *testing.T) {
+		h, _ := newBatchHarness(2)
+		defer h.Close()
+
+		// the error is reported for the message which is not requeued like the error returned by Handle
+		deliveries := h.PublishConcurrently("BATCH_TOPIC", batchBodies("ok", "ok")...)
+		for _, d := range deliveries {
+			assert.True(t, d[0].Finished)
+		}
+		warnings := 0
+		for _, r := range h.Reports() {
+			if r.Level == "warning" && strings.Contains(r.Message, "err: BATCH_RETRY") {
+				warnings++
+			}
+		}
+		assert.Equal(t, 2, warnings)
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		h, bh := newBatchHarness(2)
+		defer h.Close()
+
+		// HandleBatch is called within the context of the first message of the batch
+		h.PublishConcurrently("BATCH_TOPIC", batchBodies("ok", "ok")...)
+		assert.Len(t, bh.metas, 1)
+		assert.Equal(t, "BATCH_TOPIC", bh.metas[0].Topic)
+		assert.Equal(t, "BATCH_CHANNEL", bh.metas[0].Channel)
+		assert.NotEmpty(t, bh.metas[0].ID)
+	})
+
+	t.Run("Window", func(t *testing.T) {
+		h, bh := newBatchHarness(3)
+		defer h.Close()
+
+		start := time.Now()
+		deliveries := h.PublishConcurrently("BATCH_TOPIC", batchBodies("ok")...)
+		assert.True(t, time.Since(start) >= time.Second)
+		assert.Equal(t, [][]int64{{1}}, bh.batches)
+		assert.True(t, deliveries[0][0].Finished)
+	})
+
+	t.Run("RequeueAll", func(t *testing.T) {
+		for _, mode := range []string{"fail", "panic", "mismatch"} {
+			h, _ := newBatchHarness(2)
+			deliveries := h.PublishConcurrently("BATCH_TOPIC", batchBodies("ok", mode)...)
+			for _, d := range deliveries {
+				assert.True(t, d[0].Requeued, mode)
+				assert.Error(t, d[0].Err, mode)
+			}
+			h.Close()
+		}
+	})
+
+	t.Run("CancelledContext", func(t *testing.T) {
+		h, _ := newBatchHarness(1)
+		defer h.Close()
+
+		// the pipeliner is closed with the handler, not when the context of the consumer is cancelled by Stop
+		h.consumer.cancel()
+		time.Sleep(10 * time.Millisecond)
+		deliveries := h.Publish("BATCH_TOPIC", batchBodies("ok")[0])
+		assert.True(t, deliveries[0].Finished)
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		h, _ := newBatchHarness(2)
+		h.Close()
+		time.Sleep(10 * time.Millisecond)
+
+		deliveries := h.Publish("BATCH_TOPIC", batchBodies("ok")[0])
+		assert.True(t, deliveries[0].Requeued)
+	})
+}
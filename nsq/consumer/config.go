@@ -35,13 +35,32 @@ func (c *Config) AddConsumerConfig(cc *ConsumerConfig) {
 // MaxInFlight: number of message that will be pulled from server for every call
 // Concurrency: number of asyncronous handler that will process the message from server
 // SkipValidation: if you does not want to validate the data, by toggle this field to true will increase the performance
+// DeadLetterTopic: topic which receives the message wrapped in DeadLetter when it is still requeued at its last attempt
+// Backoff: requeue delay policy, by default the message is requeued after 1 second
+// Timeout: deadline of the context passed to the handler for every message, ignored if zero
+// Deduplicator: process the same message once within DeduplicatorTTL using the storage set by Consumer.SetStorage
+// DeduplicatorTTL: how long the processed message is remembered, default is 3 minutes
+// DeduplicatorKey: dot separated path of the field identifying the message, e.g. "event_id". Default is the whole body
+// OrderingKey: dot separated path of the field, e.g. "user_id". The messages having the same value are processed serially
+// in order by one of Concurrency lanes while the other values are processed concurrently. Ignored by HandleBatch.
+// The codec of the topics must decode the payload into a map, e.g. json, gzip+json or msgpack but not protobuf
+// BatchSize: max number of messages passed to HandleBatch, default is 100. MaxInFlight should be at least BatchSize
+// BatchWindow: max time the first message waits for the batch to be full before HandleBatch is called, default is 100ms
 type ConsumerConfig struct {
-	Name           string  `json:"name"`
-	Topics         []Topic `json:"topics"`
-	MaxInFlight    int     `json:"maxInFlight"`
-	Concurrency    int     `json:"concurrency"`
-	SkipValidation bool    `json:"skipValidation"`
-	Deduplicator   bool    `json:"deduplicator"`
+	Name            string   `json:"name"`
+	Topics          []Topic  `json:"topics"`
+	MaxInFlight     int      `json:"maxInFlight"`
+	Concurrency     int      `json:"concurrency"`
+	SkipValidation  bool     `json:"skipValidation"`
+	Deduplicator    bool     `json:"deduplicator"`
+	DeadLetterTopic string   `json:"deadLetterTopic"`
+	Backoff         *Backoff `json:"backoff"`
+	Timeout         Duration `json:"timeout"`
+	DeduplicatorTTL Duration `json:"deduplicatorTTL"`
+	DeduplicatorKey string   `json:"deduplicatorKey"`
+	OrderingKey     string   `json:"orderingKey"`
+	BatchSize       int      `json:"batchSize"`
+	BatchWindow     Duration `json:"batchWindow"`
 }
 
 // NewConsumerConfig is factory that is used to create ConsumerConfig object.
@@ -83,9 +102,11 @@ func (cc *consumerConfigs) Decode(value string) error {
 // * We have consumer that registered to 2 topic, but we use same consumer handler. to identify where the message come from, we can use tag
 // ** Topic 1. add tag {"source": "topic-1", "expireDuration": "1h"}
 // ** Topic 2. add tag {"source": "topic-2", "expireDuration": "2h"}
+// Codec is the name of nsqcodec.Codec used to decode the message body, default is "json"
 type Topic struct {
-	Name string
-	Tags map[string]interface{}
+	Name  string
+	Tags  map[string]interface{}
+	Codec string
 }
 
 // NewTopic ...
@@ -99,17 +120,19 @@ func NewTopic(name string, tags map[string]interface{}) *Topic {
 // UnmarshalJSON is used to handle backward compatibility configuration
 // Acceptable topic parameter
 // 1. "ACCOUNTS_DEVICE_REGISTRATION"
-// 2. {"name": "ACCOUNTS_DEVICE_REGISTRATION", "tags": {"source": "accounts-device-registration"}}
+// 2. {"name": "ACCOUNTS_DEVICE_REGISTRATION", "tags": {"source": "accounts-device-registration"}, "codec": "gzip+json"}
 func (t *Topic) UnmarshalJSON(b []byte) error {
 	var v struct {
-		Name string                 `json:"name"`
-		Tags map[string]interface{} `json:"tags"`
+		Name  string                 `json:"name"`
+		Tags  map[string]interface{} `json:"tags"`
+		Codec string                 `json:"codec"`
 	}
 
 	err := json.Unmarshal(b, &v)
 	if err == nil {
 		t.Name = v.Name
 		t.Tags = v.Tags
+		t.Codec = v.Codec
 		return nil
 	}
 
@@ -1,6 +1,7 @@
 package nsqconsumer
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 
@@ -60,6 +61,13 @@ func Test_consumerConfigs_Decode(t *testing.T) {
 	}
 }
 
+func TestTopic_UnmarshalJSON(t *testing.T) {
+	var topics []Topic
+	err := json.Unmarshal([]byte(`["TEST_TOPIC_1", {"name": "TEST_TOPIC_2", "codec": "gzip+json"}]`), &topics)
+	assert.Nil(t, err)
+	assert.Equal(t, []Topic{{Name: "TEST_TOPIC_1"}, {Name: "TEST_TOPIC_2", Codec: "gzip+json"}}, topics)
+}
+
 func TestNewTopic(t *testing.T) {
 	type args struct {
 		name string
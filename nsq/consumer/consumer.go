@@ -2,19 +2,17 @@ package nsqconsumer
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
 	"reflect"
+	"sync"
 	"syscall"
-	"time"
 
-	form "devcode.xeemore.com/systech/gojunkyard/form"
-	deduplicator "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/internal/deduplicator"
-	panicrecover "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/internal/panic"
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
 	storage "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/storage"
 	nop_storage "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/storage/nop"
+	nsqproducer "devcode.xeemore.com/systech/gojunkyard/nsq/producer"
 	reporter "devcode.xeemore.com/systech/gojunkyard/reporter"
 	nop_reporter "devcode.xeemore.com/systech/gojunkyard/reporter/nop"
 
@@ -24,17 +22,26 @@ import (
 // Handler is the interface for each consumer handler
 // Name must be the channel name
 // Handle is the function which return "func (*type) error" used to handle the message from NSQ server
+// HandleBatch can be implemented instead of Handle to handle the messages in batch, see ConsumerConfig.BatchSize.
+// Its ctx is the context of the first message of the batch, e.g. GetMessageMeta returns the meta of that message
+// *Router dispatches the messages to the typed handlers by the discriminator field of the payload
 type Handler interface {
 	Name() string
 }
 
 // Consumer is the which handle all of handler and config
 type Consumer struct {
-	config    *Config
-	storage   storage.Storage
-	reporter  reporter.Reporter
-	handlers  map[string]Handler
-	consumers []*nsq.Consumer
+	config      *Config
+	storage     storage.Storage
+	reporter    reporter.Reporter
+	deadLetter  nsqproducer.IProducer
+	handlers    map[string]Handler
+	middlewares []Middleware
+	consumers   []*subscription
+	mu          sync.RWMutex
+	reloadMu    sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
 }
 
 // NewConsumer will create *Consumer object
@@ -44,7 +51,7 @@ func NewConsumer(cfg *Config) *Consumer {
 		storage:   nop_storage.New(),
 		reporter:  nop_reporter.NewNopReporter(),
 		handlers:  make(map[string]Handler, len(cfg.Consumers)),
-		consumers: make([]*nsq.Consumer, 0, len(cfg.Consumers)),
+		consumers: make([]*subscription, 0, len(cfg.Consumers)),
 	}
 }
 
@@ -58,12 +65,38 @@ func (c *Consumer) SetStorage(storage storage.Storage) {
 	c.storage = storage
 }
 
+// SetDeadLetterProducer sets the producer used to publish the message to ConsumerConfig.DeadLetterTopic
+func (c *Consumer) SetDeadLetterProducer(producer nsqproducer.IProducer) {
+	c.deadLetter = producer
+}
+
 // RegisterHandler will add all handler which will be used at config
 func (c *Consumer) RegisterHandler(h Handler) {
 	if _, ok := c.handlers[h.Name()]; ok {
 		panic(fmt.Sprintf("[NSQ] Handler.Handle: %s has been registered", h.Name()))
 	}
 
+	if _, ok := h.(*Router); ok {
+		c.handlers[h.Name()] = h
+		return
+	}
+
+	if isBatchHandler(h) {
+		typ := reflect.ValueOf(h).MethodByName("HandleBatch").Type()
+		if typ.NumIn() != 3 ||
+			typ.In(0) != reflect.TypeOf((*context.Context)(nil)).Elem() ||
+			typ.In(1) != reflect.TypeOf((*map[string]interface{})(nil)).Elem() ||
+			typ.In(2).Kind() != reflect.Slice ||
+			typ.In(2).Elem().Kind() != reflect.Ptr ||
+			typ.NumOut() != 2 ||
+			typ.Out(0) != reflect.TypeOf([]bool(nil)) ||
+			typ.Out(1) != reflect.TypeOf((*error)(nil)).Elem() {
+			panic(`[NSQ] Handler.HandleBatch must be "func (ctx context.Context, tags map[string]interface{}, in []*type) ([]bool, error)`)
+		}
+		c.handlers[h.Name()] = h
+		return
+	}
+
 	typ := reflect.ValueOf(h).MethodByName("Handle").Type()
 	if typ.Kind() != reflect.Func ||
 		typ.NumIn() != 3 ||
@@ -81,11 +114,10 @@ func (c *Consumer) RegisterHandler(h Handler) {
 
 // init is used for initialize all handler based on config
 func (c *Consumer) init() {
-	var (
-		panicrecover = panicrecover.New(c.reporter)
-		deduplicator = deduplicator.New(c.reporter, c.storage)
-		nsqConfig    = nsq.NewConfig()
-	)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	for _, v := range c.config.Consumers {
 		h, ok := c.handlers[v.Name]
@@ -94,120 +126,82 @@ func (c *Consumer) init() {
 			continue
 		}
 
-		var (
-			val      = reflect.ValueOf(h).MethodByName("Handle")
-			typ      = val.Type()
-			elem     = typ.In(2).Elem()
-			isStruct = elem.Kind() == reflect.Struct
-			reporter = c.reporter
-		)
-
-		for _, t := range v.Topics {
-			// Don't remove this declaration!
-			var (
-				topic          = t
-				channel        = v.Name
-				skipValidation = v.SkipValidation
-			)
-
-			var h nsq.Handler = nsq.HandlerFunc(func(m *nsq.Message) error {
-				var in = reflect.New(elem).Interface()
-
-				// step 1. get request payload
-				err := json.Unmarshal(m.Body, in)
-				if err != nil {
-					reporter.Warningf(
-						"[NSQ] Consumer failed unmarshaling data. topic: %s, channel: %s, message: %s, err: %s",
-						topic, channel, m.Body, err,
-					)
-					return nil
-				}
-
-				// step 2. do validation if it is not skipped
-				if !skipValidation && isStruct {
-					err = form.Validate(in)
-					if err != nil {
-						reporter.Warningf(
-							"[NSQ] Consumer detects invalid body. topic: %s, channel: %s, message: %s, err: %s",
-							topic, channel, m.Body, err,
-						)
-						return nil
-					}
-				}
-
-				// step 3. call the value and get the (requeue and error)
-				var (
-					ret = val.Call([]reflect.Value{
-						reflect.ValueOf(context.Background()),
-						reflect.ValueOf(topic.Tags),
-						reflect.ValueOf(in),
-					})
-					requeue = ret[0].Bool()
-				)
-
-				// step 4. if not requeue, then return err
-				err, _ = ret[1].Interface().(error)
-				if !requeue {
-					if err != nil {
-						reporter.Warningf(
-							"[NSQ] Consumer detects error, but does not requeue. topic: %s, channel: %s, message: %s, err: %s",
-							topic, channel, m.Body, err,
-						)
-						return nil
-					}
-					reporter.Infof(
-						"[NSQ] Consumer successfully process the message. topic: %s, channel: %s, message: %s",
-						topic, channel, m.Body,
-					)
-					return nil
-				}
-
-				// step 5. if requeue and stil have requeue attempt
-				if m.Attempts <= nsqConfig.MaxAttempts {
-					m.Requeue(time.Second)
-					reporter.Errorf(
-						"[NSQ] Consumer is requeuing the message. topic: %s, channel: %s, message: %s, err: %s",
-						topic, channel, m.Body, err,
-					)
-					return err
-				}
-
-				// step 6. if requeue attempt is more than max attempt
-				// >>> PUBLISH_MESSAGE_TO_EXCEPTION_HERE <<< //
-				reporter.Errorf(
-					"[NSQ] Consumer cannot requeue the message due to reaching max attempts. topic: %s, channel: %s, message: %s, err: %s",
-					topic, channel, m.Body, err,
-				)
-				return err
-			})
-
-			consumer, err := nsq.NewConsumer(topic.Name, channel, nsqConfig)
+		for _, topic := range v.Topics {
+			sub, err := c.subscribe(v, topic, h)
 			if err != nil {
-				panic(fmt.Sprintf("[NSQ] Failed to init consumer. topic: %s, channel: %s, err: %s\n", topic, channel, err))
+				panic(fmt.Sprintf("[NSQ] Failed to init consumer. topic: %s, channel: %s, err: %s\n", topic, v.Name, err))
 			}
+			c.consumers = append(c.consumers, sub)
+		}
+	}
+}
 
-			consumer.SetLogger(nil, nsq.LogLevelError)
-			consumer.ChangeMaxInFlight(v.MaxInFlight)
-			consumer.AddConcurrentHandlers(
-				panicrecover(deduplicator.Handle(topic.Name, channel, h)),
-				v.Concurrency,
-			)
+// subscribe creates the nsq consumer of the topic and channel cc handled by h. It must be called after init
+func (c *Consumer) subscribe(cc *ConsumerConfig, topic Topic, h Handler) (*subscription, error) {
+	if err := validateHandler(cc, topic, h); err != nil {
+		return nil, err
+	}
 
-			c.consumers = append(c.consumers, consumer)
-		}
+	nsqConfig := nsq.NewConfig()
+	consumer, err := nsq.NewConsumer(topic.Name, cc.Name, nsqConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	handler := c.newHandler(ctx, cc, topic, h, nsqConfig.MaxAttempts)
+
+	consumer.SetLogger(nil, nsq.LogLevelError)
+	consumer.ChangeMaxInFlight(cc.MaxInFlight)
+	consumer.AddConcurrentHandlers(handler, cc.handlerConcurrency(h))
+
+	return &subscription{
+		topic:       topic.Name,
+		channel:     cc.Name,
+		maxInFlight: cc.MaxInFlight,
+		consumer:    consumer,
+		config:      cc,
+		topicConfig: topic,
+		handler:     handler,
+		cancel:      cancel,
+	}, nil
+}
+
+// validateHandler checks the codec of topic can decode the payload into the generic map when h is *Router or
+// cc.OrderingKey is set, otherwise every message would fail the lookup of the discriminator or the ordering key
+func validateHandler(cc *ConsumerConfig, topic Topic, h Handler) error {
+	codec, err := nsqcodec.Get(topic.Codec)
+	if err != nil {
+		return err
+	}
+
+	router, isRouter := h.(*Router)
+	switch {
+	case isRouter && !canDecodeAny(codec):
+		return fmt.Errorf("codec %s cannot decode the payload to look up the router field %q", codec.Name(), router.field)
+	case cc.OrderingKey != "" && !isBatchHandler(h) && !canDecodeAny(codec):
+		return fmt.Errorf("codec %s cannot decode the payload to look up the ordering key %q", codec.Name(), cc.OrderingKey)
 	}
+	return nil
+}
+
+// connect connects the subscription to the nsqlookupd and nsqd of the configuration
+func (c *Consumer) connect(sub *subscription) error {
+	err := sub.consumer.ConnectToNSQLookupds(c.config.NSQLookupd)
+	if err != nil {
+		return err
+	}
+	return sub.consumer.ConnectToNSQDs(c.config.NSQD)
 }
 
 // Run will start the nsq server
 func (c *Consumer) Run() error {
 	c.init()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	for _, v := range c.consumers {
-		err := v.ConnectToNSQLookupds(c.config.NSQLookupd)
-		if err != nil {
-			return err
-		}
-		err = v.ConnectToNSQDs(c.config.NSQD)
-		if err != nil {
+		if err := c.connect(v); err != nil {
 			return err
 		}
 	}
@@ -231,12 +225,20 @@ func (c *Consumer) RunGraceful() error {
 	return nil
 }
 
-// Stop is used for gracefully stop the nsq consumer
+// Stop is used for gracefully stop the nsq consumer. The context of in-flight messages is cancelled
 func (c *Consumer) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.RLock()
+	for _, v := range c.consumers {
+		v.consumer.Stop()
+	}
 	for _, v := range c.consumers {
-		v.Stop()
+		<-v.consumer.StopChan
 	}
 	for _, v := range c.consumers {
-		<-v.StopChan
+		v.close()
 	}
+	c.mu.RUnlock()
 }
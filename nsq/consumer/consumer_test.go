@@ -9,7 +9,6 @@ import (
 	nop_storage "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/storage/nop"
 	nop_reporter "devcode.xeemore.com/systech/gojunkyard/reporter/nop"
 
-	nsq "github.com/nsqio/go-nsq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -45,7 +44,7 @@ func TestNewConsumer(t *testing.T) {
 		want = &Consumer{
 			config:    cfg,
 			handlers:  make(map[string]Handler, len(cfg.Consumers)),
-			consumers: make([]*nsq.Consumer, 0, len(cfg.Consumers)),
+			consumers: make([]*subscription, 0, len(cfg.Consumers)),
 			reporter:  nop_reporter.NewNopReporter(),
 			storage:   nop_storage.New(),
 		}
@@ -0,0 +1,81 @@
+package nsqconsumer
+
+import (
+	"context"
+	"time"
+
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+type ctxK struct{}
+
+// MessageMeta holds the metadata of the message which is being handled
+type MessageMeta struct {
+	ID          string
+	Attempts    uint16
+	Timestamp   time.Time
+	Topic       string
+	Channel     string
+	NSQDAddress string
+	Headers     nsqenvelope.Headers
+}
+
+// withMessageMeta returns the copy of ctx carrying the metadata of m and the headers of its envelope
+func withMessageMeta(ctx context.Context, topic, channel string, m *nsq.Message, headers nsqenvelope.Headers) context.Context {
+	return context.WithValue(ctx, ctxK{}, MessageMeta{
+		ID:          string(m.ID[:]),
+		Attempts:    m.Attempts,
+		Timestamp:   time.Unix(0, m.Timestamp),
+		Topic:       topic,
+		Channel:     channel,
+		NSQDAddress: m.NSQDAddress,
+		Headers:     headers,
+	})
+}
+
+// GetMessageMeta returns the metadata of the message handled within ctx
+func GetMessageMeta(ctx context.Context) (MessageMeta, bool) {
+	if ctx == nil {
+		return MessageMeta{}, false
+	}
+	meta, ok := ctx.Value(ctxK{}).(MessageMeta)
+	return meta, ok
+}
+
+// GetMessageID returns the id of the message handled within ctx
+func GetMessageID(ctx context.Context) string {
+	meta, _ := GetMessageMeta(ctx)
+	return meta.ID
+}
+
+// GetAttempts returns the number of attempts of the message handled within ctx
+func GetAttempts(ctx context.Context) uint16 {
+	meta, _ := GetMessageMeta(ctx)
+	return meta.Attempts
+}
+
+// GetTimestamp returns the time when the message handled within ctx is published
+func GetTimestamp(ctx context.Context) time.Time {
+	meta, _ := GetMessageMeta(ctx)
+	return meta.Timestamp
+}
+
+// GetTopic returns the topic of the message handled within ctx
+func GetTopic(ctx context.Context) string {
+	meta, _ := GetMessageMeta(ctx)
+	return meta.Topic
+}
+
+// GetChannel returns the channel of the message handled within ctx
+func GetChannel(ctx context.Context) string {
+	meta, _ := GetMessageMeta(ctx)
+	return meta.Channel
+}
+
+// GetHeaders returns the envelope headers of the message handled within ctx, nil if the message is not enveloped
+func GetHeaders(ctx context.Context) nsqenvelope.Headers {
+	meta, _ := GetMessageMeta(ctx)
+	return meta.Headers
+}
@@ -0,0 +1,34 @@
+package nsqconsumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nsq "github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMessageMeta(t *testing.T) {
+	_, ok := GetMessageMeta(nil)
+	assert.False(t, ok)
+	_, ok = GetMessageMeta(context.Background())
+	assert.False(t, ok)
+	assert.Empty(t, GetMessageID(context.Background()))
+
+	now := time.Now()
+	m := nsq.NewMessage(nsq.MessageID{'0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'a', 'b', 'c', 'd', 'e', 'f'}, nil)
+	m.Attempts = 3
+	m.Timestamp = now.UnixNano()
+	m.NSQDAddress = "127.0.0.1:4150"
+
+	ctx := withMessageMeta(context.Background(), "TOPIC", "CHANNEL", m, nil)
+	meta, ok := GetMessageMeta(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1:4150", meta.NSQDAddress)
+	assert.Equal(t, "0123456789abcdef", GetMessageID(ctx))
+	assert.Equal(t, uint16(3), GetAttempts(ctx))
+	assert.True(t, now.Equal(GetTimestamp(ctx)))
+	assert.Equal(t, "TOPIC", GetTopic(ctx))
+	assert.Equal(t, "CHANNEL", GetChannel(ctx))
+}
@@ -0,0 +1,51 @@
+package nsqconsumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// DeadLetter is the envelope published to ConsumerConfig.DeadLetterTopic when a message still asks to be requeued
+// at its last attempt. Body holds the original message when it is a valid JSON, otherwise RawBody is used
+type DeadLetter struct {
+	Topic     string          `json:"topic"`
+	Channel   string          `json:"channel"`
+	MessageID string          `json:"messageId"`
+	Attempts  uint16          `json:"attempts"`
+	Error     string          `json:"error,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	RawBody   []byte          `json:"rawBody,omitempty"`
+}
+
+// newDeadLetter wraps the message m consumed from topic and channel into DeadLetter envelope
+func newDeadLetter(topic, channel string, m *nsq.Message, err error) *DeadLetter {
+	dl := &DeadLetter{
+		Topic:     topic,
+		Channel:   channel,
+		MessageID: string(m.ID[:]),
+		Attempts:  m.Attempts,
+		Timestamp: time.Now(),
+	}
+	if err != nil {
+		dl.Error = err.Error()
+	}
+	if json.Valid(m.Body) {
+		dl.Body = m.Body
+	} else {
+		dl.RawBody = m.Body
+	}
+	return dl
+}
+
+// publishDeadLetter publishes the message to deadLetterTopic. It returns error if there is no dead letter producer
+// or the publishing is failed, so the caller can fallback to requeue the message
+func (c *Consumer) publishDeadLetter(deadLetterTopic, topic, channel string, m *nsq.Message, err error) error {
+	if c.deadLetter == nil {
+		return fmt.Errorf("[NSQ] dead letter producer is not set. dead letter topic: %s", deadLetterTopic)
+	}
+	return c.deadLetter.Publish(deadLetterTopic, newDeadLetter(topic, channel, m, err))
+}
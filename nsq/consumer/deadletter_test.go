@@ -0,0 +1,72 @@
+package nsqconsumer
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	reporter "devcode.xeemore.com/systech/gojunkyard/reporter"
+
+	nsq "github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type producerMock struct {
+	mock.Mock
+}
+
+func (pm *producerMock) Init() {}
+
+func (pm *producerMock) SetReporter(reporter.Reporter) {}
+
+func (pm *producerMock) DeferredPublish(topic string, delay time.Duration, data interface{}) error {
+	return pm.Called(topic, delay, data).Error(0)
+}
+
+func (pm *producerMock) Stop() {}
+
+func (pm *producerMock) Publish(topic string, data interface{}) error {
+	return pm.Called(topic, data).Error(0)
+}
+
+func (pm *producerMock) MultiPublish(topic string, data []interface{}) error {
+	return pm.Called(topic, data).Error(0)
+}
+
+func Test_newDeadLetter(t *testing.T) {
+	m := nsq.NewMessage(nsq.MessageID{'a', 'b', 'c'}, []byte(`{"id":1}`))
+	m.Attempts = 5
+
+	dl := newDeadLetter("TOPIC", "CHANNEL", m, errors.New("FAILED"))
+	assert.Equal(t, "TOPIC", dl.Topic)
+	assert.Equal(t, "CHANNEL", dl.Channel)
+	assert.Equal(t, uint16(5), dl.Attempts)
+	assert.Equal(t, "FAILED", dl.Error)
+	assert.Equal(t, json.RawMessage(`{"id":1}`), dl.Body)
+	assert.Nil(t, dl.RawBody)
+	assert.False(t, dl.Timestamp.IsZero())
+
+	dl = newDeadLetter("TOPIC", "CHANNEL", nsq.NewMessage(nsq.MessageID{}, []byte("not-json")), nil)
+	assert.Empty(t, dl.Error)
+	assert.Nil(t, dl.Body)
+	assert.Equal(t, []byte("not-json"), dl.RawBody)
+
+	_, err := json.Marshal(dl)
+	assert.Nil(t, err)
+}
+
+func TestConsumer_publishDeadLetter(t *testing.T) {
+	var (
+		consumer = NewConsumer(NewConfig(nil, nil))
+		m        = nsq.NewMessage(nsq.MessageID{}, []byte(`{"id":1}`))
+	)
+	assert.NotNil(t, consumer.publishDeadLetter("DLQ", "TOPIC", "CHANNEL", m, nil))
+
+	pm := new(producerMock)
+	pm.On("Publish", "DLQ", mock.AnythingOfType("*nsqconsumer.DeadLetter")).Return(nil).Once()
+	consumer.SetDeadLetterProducer(pm)
+	assert.Nil(t, consumer.publishDeadLetter("DLQ", "TOPIC", "CHANNEL", m, nil))
+	pm.AssertExpectations(t)
+}
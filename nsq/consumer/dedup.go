@@ -0,0 +1,87 @@
+package nsqconsumer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// payloadKey returns the function which extracts the value at dot separated path, e.g. "event_id" or
+// "meta.event_id", from the body decoded by codec. It is used as the deduplicator and ordering key.
+// The whole payload without the envelope headers is returned when path is empty
+func payloadKey(path string, codec nsqcodec.Codec) func(m *nsq.Message) ([]byte, error) {
+	var fields []string
+	if path != "" {
+		fields = strings.Split(path, ".")
+	}
+
+	return func(m *nsq.Message) ([]byte, error) {
+		_, body, err := nsqenvelope.Decode(m.Body)
+		if err != nil || len(fields) == 0 {
+			return body, err
+		}
+
+		var v interface{}
+		if err := decodeAny(codec, body, &v); err != nil {
+			return nil, err
+		}
+
+		v, err = lookupField(v, path, fields)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	}
+}
+
+// lookupField returns the value at fields of the dot separated path in the decoded payload v
+func lookupField(v interface{}, path string, fields []string) (interface{}, error) {
+	for _, field := range fields {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %q of %q is not an object", field, path)
+		}
+		if v, ok = obj[field]; !ok || v == nil {
+			return nil, fmt.Errorf("field %q of %q is not found", field, path)
+		}
+	}
+	return v, nil
+}
+
+// decodeAny decodes data into v using codec. The json number is kept as is, so big integer keeps its precision
+func decodeAny(codec nsqcodec.Codec, data []byte, v *interface{}) error {
+	if codec != nsqcodec.JSON {
+		return codec.Unmarshal(data, v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// canDecodeAny returns true when codec decodes the payload into the generic map which payloadKey and Router
+// look up the field in, e.g. the protobuf codec cannot
+func canDecodeAny(codec nsqcodec.Codec) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	b, err := codec.Marshal(map[string]interface{}{"key": "value"})
+	if err != nil {
+		return false
+	}
+	var v interface{}
+	if err := decodeAny(codec, b, &v); err != nil {
+		return false
+	}
+	_, ok = v.(map[string]interface{})
+	return ok
+}
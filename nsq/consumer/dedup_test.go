@@ -0,0 +1,126 @@
+package nsqconsumer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+
+	nsq "github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryStorage struct {
+	mu   sync.Mutex
+	keys map[string]time.Duration
+}
+
+func (ms *memoryStorage) SetNX(key string, ttl time.Duration) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.keys == nil {
+		ms.keys = make(map[string]time.Duration)
+	}
+	if _, ok := ms.keys[key]; ok {
+		return false, nil
+	}
+	ms.keys[key] = ttl
+	return true, nil
+}
+
+func (ms *memoryStorage) Delete(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.keys, key)
+	return nil
+}
+
+func Test_payloadKey(t *testing.T) {
+	b, err := payloadKey("", nsqcodec.JSON)(nsq.NewMessage(nsq.MessageID{}, []byte(`{"event_id": 1}`)))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"event_id": 1}`, string(b))
+
+	enveloped, _ := nsqenvelope.Encode(nsqenvelope.Headers{"x-request-id": "abc"}, []byte(`{"event_id": 1}`))
+	b, err = payloadKey("", nsqcodec.JSON)(nsq.NewMessage(nsq.MessageID{}, enveloped))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"event_id": 1}`, string(b))
+	b, err = payloadKey("event_id", nsqcodec.JSON)(nsq.NewMessage(nsq.MessageID{}, enveloped))
+	assert.Nil(t, err)
+	assert.Equal(t, `1`, string(b))
+
+	key := payloadKey("meta.event_id", nsqcodec.JSON)
+	b, err = key(nsq.NewMessage(nsq.MessageID{}, []byte(`{"meta": {"event_id": 12345678901234567890}, "ts": 1}`)))
+	assert.Nil(t, err)
+	assert.Equal(t, "12345678901234567890", string(b))
+
+	_, err = key(nsq.NewMessage(nsq.MessageID{}, []byte(`{"meta": "abc"}`)))
+	assert.NotNil(t, err)
+	_, err = key(nsq.NewMessage(nsq.MessageID{}, []byte(`{"meta": {}}`)))
+	assert.NotNil(t, err)
+	_, err = key(nsq.NewMessage(nsq.MessageID{}, []byte(`not-json`)))
+	assert.NotNil(t, err)
+
+	body, _ := nsqcodec.GzipJSON.Marshal(map[string]string{"event_id": "abc"})
+	b, err = payloadKey("event_id", nsqcodec.GzipJSON)(nsq.NewMessage(nsq.MessageID{}, body))
+	assert.Nil(t, err)
+	assert.Equal(t, `"abc"`, string(b))
+}
+
+func Test_canDecodeAny(t *testing.T) {
+	for _, codec := range []nsqcodec.Codec{nsqcodec.JSON, nsqcodec.GzipJSON, nsqcodec.Msgpack} {
+		assert.True(t, canDecodeAny(codec), codec.Name())
+	}
+	assert.False(t, canDecodeAny(nsqcodec.Protobuf))
+	assert.False(t, canDecodeAny(panicCodec{}))
+}
+
+func TestHarness_Deduplicator(t *testing.T) {
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(&ConsumerConfig{
+		Name:            "HARNESS_CHANNEL",
+		Topics:          []Topic{{Name: "HARNESS_TOPIC", Tags: map[string]interface{}{"source": "harness"}}},
+		Deduplicator:    true,
+		DeduplicatorTTL: Duration(time.Hour),
+		DeduplicatorKey: "id",
+	})
+
+	var (
+		hh       = new(harnessHandler)
+		storage  = new(memoryStorage)
+		consumer = NewConsumer(cfg)
+	)
+	consumer.SetStorage(storage)
+	consumer.RegisterHandler(hh)
+	h := NewHarness(consumer)
+
+	h.Publish("HARNESS_TOPIC", []byte(`{"id": 1, "mode": "a"}`))
+	h.Publish("HARNESS_TOPIC", []byte(`{"id": 1, "mode": "b"}`))
+	assert.Equal(t, 1, hh.calls)
+	for _, ttl := range storage.keys {
+		assert.Equal(t, time.Hour, ttl)
+	}
+
+	// the failed message is forgotten, so it can be processed again
+	h.Publish("HARNESS_TOPIC", []byte(`{"id": 2, "mode": "retry"}`))
+	h.Publish("HARNESS_TOPIC", []byte(`{"id": 2, "mode": "retry"}`))
+	assert.Equal(t, 3, hh.calls)
+
+	// the panicking message is forgotten too, so the redelivery is not dropped as duplicate
+	d := h.Publish("HARNESS_TOPIC", []byte(`{"id": 3, "mode": "panic"}`))
+	assert.True(t, d[0].Requeued)
+	assert.Len(t, storage.keys, 1)
+	d = h.Publish("HARNESS_TOPIC", []byte(`{"id": 3, "mode": "panic"}`))
+	assert.True(t, d[0].Requeued)
+	assert.Equal(t, 5, hh.calls)
+
+	// deduplicator is disabled by default
+	h, hh = newTestHarness(&ConsumerConfig{
+		Name:   "HARNESS_CHANNEL",
+		Topics: []Topic{{Name: "HARNESS_TOPIC", Tags: map[string]interface{}{"source": "harness"}}},
+	})
+	h.Publish("HARNESS_TOPIC", []byte(`{"id": 1}`))
+	h.Publish("HARNESS_TOPIC", []byte(`{"id": 1}`))
+	assert.Equal(t, 2, hh.calls)
+}
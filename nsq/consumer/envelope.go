@@ -0,0 +1,31 @@
+package nsqconsumer
+
+import (
+	"context"
+
+	requestid "devcode.xeemore.com/systech/gojunkyard/middleware/requestid"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// startSpan starts the consumer span following the span context carried by headers, and returns the copy of ctx
+// carrying the span and the request id of headers, so both are propagated from the publisher to the handler
+func startSpan(ctx context.Context, topic, channel string, headers nsqenvelope.Headers) (opentracing.Span, context.Context) {
+	if id := headers[nsqenvelope.HeaderRequestID]; id != "" {
+		ctx = requestid.NewContext(ctx, id)
+	}
+
+	opts := []opentracing.StartSpanOption{
+		ext.SpanKindConsumer,
+		opentracing.Tag{Key: string(ext.MessageBusDestination), Value: topic},
+		opentracing.Tag{Key: "nsq.channel", Value: channel},
+	}
+	if spanCtx, err := nsqenvelope.SpanContext(headers); err == nil {
+		opts = append(opts, opentracing.FollowsFrom(spanCtx))
+	}
+
+	span := opentracing.GlobalTracer().StartSpan("nsq.consume "+topic, opts...)
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
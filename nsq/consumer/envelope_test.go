@@ -0,0 +1,93 @@
+package nsqconsumer
+
+import (
+	"context"
+	"testing"
+
+	requestid "devcode.xeemore.com/systech/gojunkyard/middleware/requestid"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+type envelopeHandler struct {
+	requestID string
+	headers   nsqenvelope.Headers
+	span      opentracing.Span
+	payload   *harnessPayload
+}
+
+func (eh *envelopeHandler) Name() string {
+	return "ENVELOPE_CHANNEL"
+}
+
+func (eh *envelopeHandler) Handle(ctx context.Context, tags map[string]interface{}, in *harnessPayload) (bool, error) {
+	eh.requestID = requestid.GetFromContext(ctx)
+	eh.headers = GetHeaders(ctx)
+	eh.span = opentracing.SpanFromContext(ctx)
+	eh.payload = in
+	return false, nil
+}
+
+func TestHarness_Envelope(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(&ConsumerConfig{
+		Name:   "ENVELOPE_CHANNEL",
+		Topics: []Topic{{Name: "ENVELOPE_TOPIC"}},
+	})
+
+	var (
+		eh       = new(envelopeHandler)
+		consumer = NewConsumer(cfg)
+	)
+	consumer.RegisterHandler(eh)
+	h := NewHarness(consumer)
+	defer h.Close()
+
+	t.Run("Enveloped", func(t *testing.T) {
+		parent := tracer.StartSpan("http.request")
+		ctx := opentracing.ContextWithSpan(context.Background(), parent)
+		ctx = requestid.NewContext(ctx, "0685d19c7a3741f09369271af0750eed")
+
+		d, err := h.PublishData("ENVELOPE_TOPIC", nsqenvelope.New(ctx, harnessPayload{ID: 1}).WithHeader("tenant", "xeemore"))
+		assert.Nil(t, err)
+		assert.True(t, d[0].Finished)
+
+		assert.Equal(t, &harnessPayload{ID: 1}, eh.payload)
+		assert.Equal(t, "0685d19c7a3741f09369271af0750eed", eh.requestID)
+		assert.Equal(t, "xeemore", eh.headers["tenant"])
+
+		spans := tracer.FinishedSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "nsq.consume ENVELOPE_TOPIC", spans[0].OperationName)
+		assert.Equal(t, parent.Context().(mocktracer.MockSpanContext).TraceID, spans[0].SpanContext.TraceID)
+		assert.Equal(t, parent.Context().(mocktracer.MockSpanContext).SpanID, spans[0].ParentID)
+		assert.Equal(t, eh.span, spans[0])
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		tracer.Reset()
+		d := h.Publish("ENVELOPE_TOPIC", []byte(`{"id": 2}`))
+		assert.True(t, d[0].Finished)
+
+		assert.Equal(t, &harnessPayload{ID: 2}, eh.payload)
+		assert.Empty(t, eh.requestID)
+		assert.Nil(t, eh.headers)
+		assert.Nil(t, eh.span)
+		assert.Empty(t, tracer.FinishedSpans())
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		eh.payload = nil
+		d := h.Publish("ENVELOPE_TOPIC", []byte("\x00NSQE\x01\x00"))
+		assert.True(t, d[0].Finished)
+		assert.Nil(t, eh.payload)
+		assert.Equal(t, "warning", h.Reports()[len(h.Reports())-1].Level)
+	})
+}
@@ -0,0 +1,227 @@
+package nsqconsumer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	form "devcode.xeemore.com/systech/gojunkyard/form"
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+	deduplicator "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/internal/deduplicator"
+	panicrecover "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/internal/panic"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+
+	nsq "github.com/nsqio/go-nsq"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// newHandler builds the nsq.Handler which processes the message of topic consumed by cc using h.
+// The message which is still requeued at maxAttempts is published to the dead letter topic.
+// When h is *Router, the payload is decoded into the type routed by its discriminator field.
+// When h implements HandleBatch, the messages are collected into batches by newBatchHandler.
+// Otherwise when cc.OrderingKey is set, the messages are dispatched to the lanes of orderedHandler
+func (c *Consumer) newHandler(ctx context.Context, cc *ConsumerConfig, topic Topic, h Handler, maxAttempts uint16) nsq.Handler {
+	var (
+		isBatch          = isBatchHandler(h)
+		router, isRouter = h.(*Router)
+		val              reflect.Value
+		elem             reflect.Type
+	)
+	switch {
+	case isRouter:
+	case isBatch:
+		val = reflect.ValueOf(h).MethodByName("HandleBatch")
+		elem = val.Type().In(2).Elem().Elem()
+	default:
+		val = reflect.ValueOf(h).MethodByName("Handle")
+		elem = val.Type().In(2).Elem()
+	}
+
+	var (
+		reporter        = c.reporter
+		channel         = cc.Name
+		skipValidation  = cc.SkipValidation
+		deadLetterTopic = cc.DeadLetterTopic
+		backoff         = cc.Backoff
+		timeout         = time.Duration(cc.Timeout)
+	)
+
+	codec, err := nsqcodec.Get(topic.Codec)
+	if err != nil {
+		panic(fmt.Sprintf("[NSQ] Failed to init consumer. topic: %s, channel: %s, err: %s\n", topic, channel, err))
+	}
+
+	mws := make([]Middleware, 0, len(c.middlewares)+2)
+	mws = append(mws, Recovery(c.reporter))
+	mws = append(mws, c.middlewares...)
+	if cc.Deduplicator {
+		mws = append(mws, deduplication(deduplicator.New(
+			c.reporter, c.storage, time.Duration(cc.DeduplicatorTTL), payloadKey(cc.DeduplicatorKey, codec),
+		)))
+	}
+	handle := func(ctx context.Context, msg *Message) (bool, error) {
+		ret := msg.handle.Call([]reflect.Value{
+			reflect.ValueOf(ctx),
+			reflect.ValueOf(msg.Tags),
+			reflect.ValueOf(msg.Payload),
+		})
+		err, _ := ret[1].Interface().(error)
+		return ret[0].Bool(), err
+	}
+	decode := func(body []byte) (interface{}, reflect.Value, error) {
+		in := reflect.New(elem).Interface()
+		return in, val, codec.Unmarshal(body, in)
+	}
+	var closeBatch func()
+	switch {
+	case isRouter:
+		decode = router.decoder(codec)
+	case isBatch:
+		handle, closeBatch = c.newBatchHandler(cc, topic, val)
+	}
+	next := chain(handle, mws...)
+
+	// respond finishes or requeues m following the decision of the handler
+	respond := func(m *nsq.Message, requeue bool, err error) error {
+		// step 4. if not requeue, then return err
+		delay, hasDelay := requeueDelay(err)
+		if !hasDelay {
+			delay = backoff.Duration(m.Attempts)
+		}
+		if !requeue && !hasDelay {
+			if err != nil {
+				reporter.Warningf(
+					"[NSQ] Consumer detects error, but does not requeue. topic: %s, channel: %s, message: %s, err: %s",
+					topic, channel, m.Body, err,
+				)
+				return nil
+			}
+			reporter.Infof(
+				"[NSQ] Consumer successfully process the message. topic: %s, channel: %s, message: %s",
+				topic, channel, m.Body,
+			)
+			return nil
+		}
+
+		// step 5. if requeue and stil have requeue attempt
+		if m.Attempts < maxAttempts {
+			m.Requeue(delay)
+			reporter.Errorf(
+				"[NSQ] Consumer is requeuing the message. topic: %s, channel: %s, delay: %s, message: %s, err: %s",
+				topic, channel, delay, m.Body, err,
+			)
+			return err
+		}
+
+		// step 6. if requeue attempt is reaching max attempt, publish it to dead letter topic
+		if deadLetterTopic != "" {
+			dlErr := c.publishDeadLetter(deadLetterTopic, topic.Name, channel, m, err)
+			if dlErr == nil {
+				reporter.Errorf(
+					"[NSQ] Consumer published the message to dead letter due to reaching max attempts. topic: %s, channel: %s, dead letter topic: %s, message: %s, err: %s",
+					topic, channel, deadLetterTopic, m.Body, err,
+				)
+				return nil
+			}
+			reporter.Errorf(
+				"[NSQ] Consumer failed publishing the message to dead letter. topic: %s, channel: %s, dead letter topic: %s, message: %s, err: %s",
+				topic, channel, deadLetterTopic, m.Body, dlErr,
+			)
+		}
+		reporter.Errorf(
+			"[NSQ] Consumer cannot requeue the message due to reaching max attempts. topic: %s, channel: %s, message: %s, err: %s",
+			topic, channel, m.Body, err,
+		)
+		return err
+	}
+
+	handleMessage := func(m *nsq.Message) error {
+		var (
+			in   interface{}
+			call reflect.Value
+		)
+
+		// step 1. unwrap the envelope if any and get request payload
+		headers, body, err := nsqenvelope.Decode(m.Body)
+		if err == nil {
+			in, call, err = decode(body)
+		}
+		if ute, ok := err.(*UnknownTypeError); ok {
+			return c.handleUnknownType(router.unknown, deadLetterTopic, topic.Name, channel, m, ute, respond)
+		}
+		if err != nil {
+			reporter.Warningf(
+				"[NSQ] Consumer failed unmarshaling data. topic: %s, channel: %s, codec: %s, message: %s, err: %s",
+				topic, channel, codec.Name(), m.Body, err,
+			)
+			return nil
+		}
+
+		// step 2. do validation if it is not skipped
+		if !skipValidation && reflect.TypeOf(in).Elem().Kind() == reflect.Struct {
+			err = form.Validate(in)
+			if err != nil {
+				reporter.Warningf(
+					"[NSQ] Consumer detects invalid body. topic: %s, channel: %s, message: %s, err: %s",
+					topic, channel, m.Body, err,
+				)
+				return nil
+			}
+		}
+
+		// step 3. call the middlewares and the value and get the (requeue and error)
+		ctx := withMessageMeta(ctx, topic.Name, channel, m, headers)
+		if headers != nil {
+			var span opentracing.Span
+			span, ctx = startSpan(ctx, topic.Name, channel, headers)
+			defer span.Finish()
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		requeue, err := next(ctx, &Message{
+			Message: m,
+			Topic:   topic.Name,
+			Channel: channel,
+			Tags:    topic.Tags,
+			Headers: headers,
+			Payload: in,
+			handle:  call,
+		})
+		return respond(m, requeue, err)
+	}
+
+	// the panic outside of the middlewares, e.g. raised by the codec or the dead letter producer, is recovered
+	// and the message is requeued by returning the error, so it does not crash the consumer
+	var handler nsq.Handler = nsq.HandlerFunc(func(m *nsq.Message) error {
+		_, err := panicrecover.Recover(reporter, func() (bool, error) {
+			return false, handleMessage(m)
+		})
+		return err
+	})
+
+	switch {
+	case isBatch:
+		return &closingHandler{Handler: handler, closeFunc: closeBatch}
+	case cc.OrderingKey != "":
+		oh := newOrderedHandler(
+			handler, payloadKey(cc.OrderingKey, codec), c.reporter, topic.Name, channel, cc.Concurrency, cc.MaxInFlight,
+		)
+		return oh
+	}
+	return handler
+}
+
+// closingHandler is the nsq.Handler which releases its resources on close, e.g. the batch pipeliner
+type closingHandler struct {
+	nsq.Handler
+	closeFunc func()
+}
+
+// close is called after nsq.Consumer is stopped, see subscription.close
+func (ch *closingHandler) close() {
+	ch.closeFunc()
+}
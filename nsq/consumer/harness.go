@@ -0,0 +1,364 @@
+package nsqconsumer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+	nsqproducer "devcode.xeemore.com/systech/gojunkyard/nsq/producer"
+	reporter "devcode.xeemore.com/systech/gojunkyard/reporter"
+	nop_reporter "devcode.xeemore.com/systech/gojunkyard/reporter/nop"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// Harness runs the handlers registered to the Consumer in-process without nsqd and nsqlookupd.
+// The message goes through the same decode, validation, handle, requeue, deduplication and panic recovery
+// as the message consumed by Consumer.Run, so it is used to unit test the handler
+type Harness struct {
+	consumer    *Consumer
+	maxAttempts uint16
+	handlers    map[string]map[string]nsq.Handler
+	codecs      map[string]map[string]string
+	deadLetter  *nsqproducer.MemoryProducer
+	reporter    *recordingReporter
+	sequence    uint64
+}
+
+// Delivery is the outcome of delivering a message once to a channel
+// Dropped: the message has exceeded the max attempts, so it is finished without calling the handler like nsq does
+type Delivery struct {
+	Topic        string
+	Channel      string
+	Body         []byte
+	Attempts     uint16
+	Finished     bool
+	Requeued     bool
+	RequeueDelay time.Duration
+	Dropped      bool
+	Err          error
+}
+
+// Report is the message sent to the reporter while delivering the message
+type Report struct {
+	Level   string
+	Message string
+}
+
+// NewHarness creates the harness from the configuration, handlers, middlewares, reporter and storage of
+// the consumer. The harness works on its own copy of the consumer, so c is left untouched and still can be run.
+// The dead letter producer is replaced by in-memory producer, see Harness.DeadLetters
+func NewHarness(c *Consumer) *Harness {
+	rp := c.reporter
+	if rp == nil {
+		rp = nop_reporter.NewNopReporter()
+	}
+
+	h := &Harness{
+		maxAttempts: nsq.NewConfig().MaxAttempts,
+		handlers:    make(map[string]map[string]nsq.Handler),
+		codecs:      make(map[string]map[string]string),
+		deadLetter:  nsqproducer.NewMemoryProducer(),
+		reporter:    &recordingReporter{Reporter: rp},
+	}
+
+	h.consumer = &Consumer{
+		config:      c.config,
+		storage:     c.storage,
+		reporter:    h.reporter,
+		deadLetter:  h.deadLetter,
+		handlers:    c.handlers,
+		middlewares: c.middlewares,
+	}
+	h.consumer.ctx, h.consumer.cancel = context.WithCancel(context.Background())
+	h.build()
+	return h
+}
+
+// SetMaxAttempts changes the max attempts of the message which is 5 by default like nsq.Config
+func (h *Harness) SetMaxAttempts(maxAttempts uint16) {
+	h.maxAttempts = maxAttempts
+	h.closeHandlers()
+	h.build()
+}
+
+// build creates the handler of every topic and channel in the consumer configuration
+func (h *Harness) build() {
+	for _, v := range h.consumer.config.Consumers {
+		handler, ok := h.consumer.handlers[v.Name]
+		if !ok {
+			continue
+		}
+
+		for _, topic := range v.Topics {
+			if err := validateHandler(v, topic, handler); err != nil {
+				panic(fmt.Sprintf("[NSQ] Failed to init consumer. topic: %s, channel: %s, err: %s\n", topic, v.Name, err))
+			}
+			if h.handlers[topic.Name] == nil {
+				h.handlers[topic.Name] = make(map[string]nsq.Handler)
+				h.codecs[topic.Name] = make(map[string]string)
+			}
+			h.handlers[topic.Name][v.Name] = h.consumer.newHandler(h.consumer.ctx, v, topic, handler, h.maxAttempts)
+			h.codecs[topic.Name][v.Name] = topic.Codec
+		}
+	}
+}
+
+// Deliver delivers m once to the channel subscribing topic
+func (h *Harness) Deliver(topic, channel string, m *nsq.Message) Delivery {
+	d := Delivery{Topic: topic, Channel: channel, Body: m.Body, Attempts: m.Attempts}
+
+	handler, ok := h.handlers[topic][channel]
+	if !ok {
+		d.Err = fmt.Errorf("[NSQ] Harness has no handler. topic: %s, channel: %s", topic, channel)
+		return d
+	}
+
+	delegate := &harnessDelegate{delivery: &d, done: make(chan struct{})}
+	m.Delegate = delegate
+
+	// the following flow follows nsq.Consumer handler loop
+	if h.maxAttempts > 0 && m.Attempts > h.maxAttempts {
+		d.Dropped = true
+		m.Finish()
+		return d
+	}
+
+	d.Err = handler.HandleMessage(m)
+	if m.IsAutoResponseDisabled() {
+		// the message is responded asynchronously, e.g. by the ordered lane
+		<-delegate.done
+	} else {
+		if d.Err != nil {
+			m.Requeue(-1)
+		} else {
+			m.Finish()
+		}
+	}
+	return d
+}
+
+// Publish delivers body as a new message to every channel subscribing topic
+func (h *Harness) Publish(topic string, body []byte) []Delivery {
+	deliveries := make([]Delivery, 0, len(h.handlers[topic]))
+	for channel := range h.handlers[topic] {
+		deliveries = append(deliveries, h.Deliver(topic, channel, h.newMessage(body)))
+	}
+	return deliveries
+}
+
+// PublishConcurrently delivers every body like Publish at the same time, so the messages handled by HandleBatch
+// are collected into the same batch. It returns the deliveries of every body in the same order as bodies
+func (h *Harness) PublishConcurrently(topic string, bodies ...[]byte) [][]Delivery {
+	var (
+		wg         sync.WaitGroup
+		deliveries = make([][]Delivery, len(bodies))
+	)
+	for i, body := range bodies {
+		wg.Add(1)
+		go func(i int, body []byte) {
+			defer wg.Done()
+			deliveries[i] = h.Publish(topic, body)
+		}(i, body)
+	}
+	wg.Wait()
+	return deliveries
+}
+
+// PublishData encodes data using the codec of each subscription, then delivers it like Publish.
+// Data can be *nsqenvelope.Envelope to deliver the headers
+func (h *Harness) PublishData(topic string, data interface{}) ([]Delivery, error) {
+	deliveries := make([]Delivery, 0, len(h.handlers[topic]))
+	for channel := range h.handlers[topic] {
+		codec, err := nsqcodec.Get(h.codecs[topic][channel])
+		if err != nil {
+			return deliveries, err
+		}
+		body, err := nsqenvelope.Marshal(codec, data)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, h.Deliver(topic, channel, h.newMessage(body)))
+	}
+	return deliveries, nil
+}
+
+// PublishAndRetry delivers body to every channel subscribing topic and redelivers the requeued message immediately
+// until it is finished or dropped. It returns every delivery ordered by channel attempts
+func (h *Harness) PublishAndRetry(topic string, body []byte) []Delivery {
+	var deliveries []Delivery
+	for channel := range h.handlers[topic] {
+		m := h.newMessage(body)
+		for {
+			d := h.Deliver(topic, channel, m)
+			deliveries = append(deliveries, d)
+			if !d.Requeued {
+				break
+			}
+			m = nsq.NewMessage(m.ID, m.Body)
+			m.Attempts = d.Attempts + 1
+		}
+	}
+	return deliveries
+}
+
+// DeadLetters returns every message published to the dead letter topic
+func (h *Harness) DeadLetters() []*DeadLetter {
+	var dls []*DeadLetter
+	for _, m := range h.deadLetter.Messages() {
+		if dl, ok := m.Data.(*DeadLetter); ok {
+			dls = append(dls, dl)
+		}
+	}
+	return dls
+}
+
+// DeadLetterProducer returns the in-memory producer used to publish the dead letter
+func (h *Harness) DeadLetterProducer() *nsqproducer.MemoryProducer {
+	return h.deadLetter
+}
+
+// Reports returns every message sent to the reporter
+func (h *Harness) Reports() []Report {
+	return h.reporter.reports()
+}
+
+// Close cancels the context passed to the handler and releases the resources held by the handlers
+func (h *Harness) Close() {
+	h.consumer.cancel()
+	h.closeHandlers()
+}
+
+// closeHandlers releases the resources held by the handlers, e.g. the batch pipeliner and the ordered lanes
+func (h *Harness) closeHandlers() {
+	for _, handlers := range h.handlers {
+		for _, handler := range handlers {
+			if v, ok := handler.(interface{ close() }); ok {
+				v.close()
+			}
+		}
+	}
+}
+
+func (h *Harness) newMessage(body []byte) *nsq.Message {
+	var id nsq.MessageID
+	copy(id[:], fmt.Sprintf("%016x", atomic.AddUint64(&h.sequence, 1)))
+
+	m := nsq.NewMessage(id, body)
+	m.Attempts = 1
+	return m
+}
+
+// harnessDelegate records the response of the message into delivery
+type harnessDelegate struct {
+	delivery *Delivery
+	done     chan struct{}
+}
+
+func (hd *harnessDelegate) OnFinish(*nsq.Message) {
+	hd.delivery.Finished = true
+	close(hd.done)
+}
+
+func (hd *harnessDelegate) OnRequeue(m *nsq.Message, delay time.Duration, backoff bool) {
+	hd.delivery.Requeued = true
+	hd.delivery.RequeueDelay = delay
+	close(hd.done)
+}
+
+func (hd *harnessDelegate) OnTouch(*nsq.Message) {}
+
+// recordingReporter records every report before forwarding it to the wrapped reporter
+type recordingReporter struct {
+	reporter.Reporter
+
+	mu      sync.Mutex
+	records []Report
+}
+
+func (r *recordingReporter) record(level, message string) {
+	r.mu.Lock()
+	r.records = append(r.records, Report{Level: level, Message: message})
+	r.mu.Unlock()
+}
+
+func (r *recordingReporter) reports() []Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Report(nil), r.records...)
+}
+
+func (r *recordingReporter) Debug(v ...interface{}) {
+	r.record("debug", fmt.Sprint(v...))
+	r.Reporter.Debug(v...)
+}
+
+func (r *recordingReporter) Debugf(format string, v ...interface{}) {
+	r.record("debug", fmt.Sprintf(format, v...))
+	r.Reporter.Debugf(format, v...)
+}
+
+func (r *recordingReporter) Debugln(v ...interface{}) {
+	r.record("debug", fmt.Sprint(v...))
+	r.Reporter.Debugln(v...)
+}
+
+func (r *recordingReporter) Info(v ...interface{}) {
+	r.record("info", fmt.Sprint(v...))
+	r.Reporter.Info(v...)
+}
+
+func (r *recordingReporter) Infof(format string, v ...interface{}) {
+	r.record("info", fmt.Sprintf(format, v...))
+	r.Reporter.Infof(format, v...)
+}
+
+func (r *recordingReporter) Infoln(v ...interface{}) {
+	r.record("info", fmt.Sprint(v...))
+	r.Reporter.Infoln(v...)
+}
+
+func (r *recordingReporter) Warning(v ...interface{}) {
+	r.record("warning", fmt.Sprint(v...))
+	r.Reporter.Warning(v...)
+}
+
+func (r *recordingReporter) Warningf(format string, v ...interface{}) {
+	r.record("warning", fmt.Sprintf(format, v...))
+	r.Reporter.Warningf(format, v...)
+}
+
+func (r *recordingReporter) Warningln(v ...interface{}) {
+	r.record("warning", fmt.Sprint(v...))
+	r.Reporter.Warningln(v...)
+}
+
+func (r *recordingReporter) Error(v ...interface{}) {
+	r.record("error", fmt.Sprint(v...))
+	r.Reporter.Error(v...)
+}
+
+func (r *recordingReporter) Errorf(format string, v ...interface{}) {
+	r.record("error", fmt.Sprintf(format, v...))
+	r.Reporter.Errorf(format, v...)
+}
+
+func (r *recordingReporter) Errorln(v ...interface{}) {
+	r.record("error", fmt.Sprint(v...))
+	r.Reporter.Errorln(v...)
+}
+
+func (r *recordingReporter) ReportPanic(err interface{}, stacktrace []byte) error {
+	r.record("panic", fmt.Sprint(err))
+	return r.Reporter.ReportPanic(err, stacktrace)
+}
+
+func (r *recordingReporter) ReportHTTPPanic(err interface{}, stacktrace []byte, req *http.Request) error {
+	r.record("panic", fmt.Sprint(err))
+	return r.Reporter.ReportHTTPPanic(err, stacktrace, req)
+}
@@ -0,0 +1,210 @@
+package nsqconsumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+
+	nsq "github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+type harnessPayload struct {
+	ID   int64  `json:"id" validate:"required"`
+	Mode string `json:"mode"`
+}
+
+type harnessHandler struct {
+	calls int
+}
+
+func (hh *harnessHandler) Name() string {
+	return "HARNESS_CHANNEL"
+}
+
+func (hh *harnessHandler) Handle(ctx context.Context, tags map[string]interface{}, in *harnessPayload) (bool, error) {
+	hh.calls++
+	switch in.Mode {
+	case "panic":
+		panic("HARNESS_PANIC")
+	case "retry":
+		return true, errors.New("HARNESS_RETRY")
+	case "delay":
+		return false, RequeueAfter(time.Minute, errors.New("HARNESS_DELAY"))
+	case "ignore":
+		return false, errors.New("HARNESS_IGNORE")
+	}
+	if GetTopic(ctx) != "HARNESS_TOPIC" || tags["source"] != "harness" {
+		return false, errors.New("HARNESS_INVALID_CONTEXT")
+	}
+	return false, nil
+}
+
+func newTestHarness(cc *ConsumerConfig) (*Harness, *harnessHandler) {
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(cc)
+
+	hh := new(harnessHandler)
+	consumer := NewConsumer(cfg)
+	consumer.RegisterHandler(hh)
+	return NewHarness(consumer), hh
+}
+
+func TestHarness(t *testing.T) {
+	h, hh := newTestHarness(&ConsumerConfig{
+		Name:            "HARNESS_CHANNEL",
+		Topics:          []Topic{{Name: "HARNESS_TOPIC", Tags: map[string]interface{}{"source": "harness"}}},
+		DeadLetterTopic: "HARNESS_DLQ",
+		Backoff:         &Backoff{Strategy: BackoffLinear, Delay: Duration(time.Second)},
+	})
+	defer h.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		d := h.Publish("HARNESS_TOPIC", []byte(`{"id": 1}`))
+		assert.Len(t, d, 1)
+		assert.True(t, d[0].Finished)
+		assert.False(t, d[0].Requeued)
+		assert.Nil(t, d[0].Err)
+	})
+
+	t.Run("InvalidBody", func(t *testing.T) {
+		calls := hh.calls
+		d := h.Publish("HARNESS_TOPIC", []byte(`{}`))
+		assert.True(t, d[0].Finished)
+		assert.Equal(t, calls, hh.calls)
+		assert.Equal(t, "warning", h.Reports()[len(h.Reports())-1].Level)
+	})
+
+	t.Run("Ignore", func(t *testing.T) {
+		d, err := h.PublishData("HARNESS_TOPIC", &harnessPayload{ID: 1, Mode: "ignore"})
+		assert.Nil(t, err)
+		assert.True(t, d[0].Finished)
+	})
+
+	t.Run("RequeueAfter", func(t *testing.T) {
+		d := h.Publish("HARNESS_TOPIC", []byte(`{"id": 1, "mode": "delay"}`))
+		assert.True(t, d[0].Requeued)
+		assert.Equal(t, time.Minute, d[0].RequeueDelay)
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		d := h.Publish("HARNESS_TOPIC", []byte(`{"id": 1, "mode": "panic"}`))
+		assert.True(t, d[0].Requeued)
+		assert.EqualError(t, d[0].Err, "panic: HARNESS_PANIC")
+		reports := h.Reports()
+		assert.Equal(t, Report{Level: "panic", Message: "HARNESS_PANIC"}, reports[len(reports)-2])
+		assert.Equal(t, "error", reports[len(reports)-1].Level)
+	})
+
+	t.Run("DeadLetter", func(t *testing.T) {
+		d := h.PublishAndRetry("HARNESS_TOPIC", []byte(`{"id": 1, "mode": "retry"}`))
+		assert.Len(t, d, 5)
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, uint16(i+1), d[i].Attempts)
+			assert.True(t, d[i].Requeued)
+			assert.Equal(t, time.Duration(i+1)*time.Second, d[i].RequeueDelay)
+		}
+		assert.True(t, d[4].Finished)
+
+		dls := h.DeadLetters()
+		assert.Len(t, dls, 1)
+		assert.Equal(t, "HARNESS_TOPIC", dls[0].Topic)
+		assert.Equal(t, "HARNESS_CHANNEL", dls[0].Channel)
+		assert.Equal(t, uint16(5), dls[0].Attempts)
+		assert.Equal(t, "HARNESS_RETRY", dls[0].Error)
+	})
+
+	t.Run("Dropped", func(t *testing.T) {
+		m := nsq.NewMessage(nsq.MessageID{}, []byte(`{"id": 1}`))
+		m.Attempts = 6
+		d := h.Deliver("HARNESS_TOPIC", "HARNESS_CHANNEL", m)
+		assert.True(t, d.Dropped)
+		assert.True(t, d.Finished)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		assert.Empty(t, h.Publish("UNKNOWN_TOPIC", []byte(`{}`)))
+		d := h.Deliver("UNKNOWN_TOPIC", "HARNESS_CHANNEL", nsq.NewMessage(nsq.MessageID{}, nil))
+		assert.NotNil(t, d.Err)
+	})
+}
+
+func TestHarness_SetMaxAttempts(t *testing.T) {
+	h, _ := newTestHarness(&ConsumerConfig{
+		Name:   "HARNESS_CHANNEL",
+		Topics: []Topic{{Name: "HARNESS_TOPIC"}},
+	})
+	h.SetMaxAttempts(2)
+
+	d := h.PublishAndRetry("HARNESS_TOPIC", []byte(`{"id": 1, "mode": "retry"}`))
+	assert.Len(t, d, 3)
+	assert.True(t, d[0].Requeued)
+	assert.True(t, d[1].Requeued)
+	assert.True(t, d[2].Dropped)
+	assert.Empty(t, h.DeadLetters())
+	assert.Equal(t, "error", h.Reports()[len(h.Reports())-1].Level)
+}
+
+func TestNewHarness(t *testing.T) {
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(&ConsumerConfig{Name: "HARNESS_CHANNEL", Topics: []Topic{{Name: "HARNESS_TOPIC"}}})
+	consumer := NewConsumer(cfg)
+	consumer.RegisterHandler(new(harnessHandler))
+	reporter, deadLetter := consumer.reporter, consumer.deadLetter
+
+	// the consumer is left untouched by the harness
+	h := NewHarness(consumer)
+	defer h.Close()
+	assert.Equal(t, reporter, consumer.reporter)
+	assert.Equal(t, deadLetter, consumer.deadLetter)
+	assert.Nil(t, consumer.ctx)
+	assert.NotSame(t, consumer, h.consumer)
+}
+
+func TestHarness_SetMaxAttemptsOrdered(t *testing.T) {
+	h, _ := newTestHarness(&ConsumerConfig{
+		Name:        "HARNESS_CHANNEL",
+		Topics:      []Topic{{Name: "HARNESS_TOPIC"}},
+		OrderingKey: "id",
+	})
+	defer h.Close()
+
+	// the lanes of the replaced handler are stopped
+	old := h.handlers["HARNESS_TOPIC"]["HARNESS_CHANNEL"].(*orderedHandler)
+	h.SetMaxAttempts(2)
+	assert.NotSame(t, old, h.handlers["HARNESS_TOPIC"]["HARNESS_CHANNEL"])
+	select {
+	case <-old.done:
+	default:
+		t.Error("old ordered handler is not closed")
+	}
+}
+
+// panicCodec panics while decoding like the codec given the message type it does not support
+type panicCodec struct{}
+
+func (panicCodec) Name() string                               { return "harness-panic" }
+func (panicCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (panicCodec) Unmarshal(data []byte, v interface{}) error { panic("HARNESS_CODEC_PANIC") }
+
+func TestHarness_CodecPanic(t *testing.T) {
+	nsqcodec.Register(panicCodec{})
+	h, hh := newTestHarness(&ConsumerConfig{
+		Name:   "HARNESS_CHANNEL",
+		Topics: []Topic{{Name: "HARNESS_TOPIC", Codec: "harness-panic"}},
+	})
+	defer h.Close()
+
+	var d []Delivery
+	assert.NotPanics(t, func() {
+		d = h.Publish("HARNESS_TOPIC", []byte(`{"id": 1}`))
+	})
+	assert.True(t, d[0].Requeued)
+	assert.EqualError(t, d[0].Err, "panic: HARNESS_CODEC_PANIC")
+	assert.Equal(t, "panic", h.Reports()[0].Level)
+	assert.Equal(t, 0, hh.calls)
+}
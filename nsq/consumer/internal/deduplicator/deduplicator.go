@@ -9,53 +9,88 @@ import (
 	nsq "github.com/nsqio/go-nsq"
 )
 
+// DefaultTTL is the time the message is remembered when the ttl is not set
+const DefaultTTL = 3 * time.Minute
+
+// KeyFunc returns the identity of the message. Messages which have the same identity are processed once
+type KeyFunc func(m *nsq.Message) ([]byte, error)
+
 type Deduplicator struct {
 	reporter reporter.Reporter
 	storage  storage.Storage
+	ttl      time.Duration
+	key      KeyFunc
 }
 
-func New(reporter reporter.Reporter, storage storage.Storage) *Deduplicator {
+// New creates deduplicator remembering the message for ttl. The identity of the message is the whole body
+// when key is nil, or when key fails
+func New(reporter reporter.Reporter, storage storage.Storage, ttl time.Duration, key KeyFunc) *Deduplicator {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
 	return &Deduplicator{
 		reporter: reporter,
 		storage:  storage,
+		ttl:      ttl,
+		key:      key,
 	}
 }
 
-func (d *Deduplicator) Handle(topic, channel string, h nsq.Handler) nsq.Handler {
-	return nsq.HandlerFunc(func(m *nsq.Message) error {
-		var key = calculateKey(topic, channel, m.Body)
+func (d *Deduplicator) calculateKey(topic, channel string, m *nsq.Message) string {
+	if d.key == nil {
+		return calculateKey(topic, channel, m.Body)
+	}
 
-		// 1. Set the key if not exist
-		ok, err := d.storage.SetNX(key, 3*time.Minute)
-		if err != nil {
-			d.reporter.Errorf(
-				"[NSQ_DEDUPLICATOR] Failed to set the key. topic: %s, channel: %s, message: %s, err: %s",
-				topic, channel, m.Body, err,
-			)
-			m.Requeue(time.Second)
-			return err
-		}
-		// 2. if key has been exist, then return
-		if !ok {
-			d.reporter.Warningf(
-				"[NSQ_DEDUPLICATOR] Message has been processed, topic: %s, channel: %s, ignoring the message. message: %s",
-				topic, channel, m.Body, err,
-			)
-			return nil
-		}
-		// 3. call the handler
-		err = h.HandleMessage(m)
-		if err == nil {
-			return nil
+	id, err := d.key(m)
+	if err != nil {
+		d.reporter.Warningf(
+			"[NSQ_DEDUPLICATOR] Failed to extract the key, using the whole message instead. topic: %s, channel: %s, message: %s, err: %s",
+			topic, channel, m.Body, err,
+		)
+		return calculateKey(topic, channel, m.Body)
+	}
+	return calculateKey(topic, channel, id)
+}
+
+// Handle calls h once for the messages having the same key within the ttl. The key is forgotten when h asks
+// to requeue the message, fails or panics, so the redelivered message is processed again. The panic of h is
+// raised again after the key is forgotten
+func (d *Deduplicator) Handle(topic, channel string, m *nsq.Message, h func() (bool, error)) (requeue bool, err error) {
+	var key = d.calculateKey(topic, channel, m)
+
+	// 1. Set the key if not exist
+	ok, err := d.storage.SetNX(key, d.ttl)
+	if err != nil {
+		d.reporter.Errorf(
+			"[NSQ_DEDUPLICATOR] Failed to set the key. topic: %s, channel: %s, message: %s, err: %s",
+			topic, channel, m.Body, err,
+		)
+		return true, err
+	}
+	// 2. if key has been exist, then return
+	if !ok {
+		d.reporter.Warningf(
+			"[NSQ_DEDUPLICATOR] Message has been processed, topic: %s, channel: %s, ignoring the message. message: %s",
+			topic, channel, m.Body,
+		)
+		return false, nil
+	}
+	// 3. delete from storage if it is requeued, error or panic
+	defer func() {
+		r := recover()
+		if r == nil && !requeue && err == nil {
+			return
 		}
-		// 4. delete from storage if it is error
-		err = d.storage.Delete(key)
-		if err != nil {
+		if delErr := d.storage.Delete(key); delErr != nil {
 			d.reporter.Errorf(
 				"[NSQ_DEDUPLICATOR] Consumer is requeue but cannot delete the message deduplicator.topic: %s, channel: %s, message: %s, err: %s",
-				topic, channel, m.Body, err,
+				topic, channel, m.Body, delErr,
 			)
 		}
-		return err
-	})
+		if r != nil {
+			panic(r)
+		}
+	}()
+	// 4. call the handler
+	return h()
 }
@@ -1,29 +1,23 @@
 package panic
 
 import (
+	"fmt"
 	"runtime/debug"
-	"time"
-
-	nsq "github.com/nsqio/go-nsq"
 )
 
 type Reporter interface {
 	ReportPanic(err interface{}, stacktrace []byte) error
 }
 
-// New is used to initiate panic recover middleware
-func New(rp Reporter) func(h nsq.Handler) nsq.Handler {
-	return func(h nsq.Handler) nsq.Handler {
-		return nsq.HandlerFunc(func(m *nsq.Message) error {
-			defer func() {
-				if err := recover(); err != nil {
-					if rp != nil {
-						rp.ReportPanic(err, debug.Stack())
-					}
-					m.Requeue(time.Second)
-				}
-			}()
-			return h.HandleMessage(m)
-		})
-	}
+// Recover calls h and reports the panic raised by h. The panicked message is asked to be requeued
+func Recover(rp Reporter, h func() (bool, error)) (requeue bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if rp != nil {
+				rp.ReportPanic(r, debug.Stack())
+			}
+			requeue, err = true, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h()
 }
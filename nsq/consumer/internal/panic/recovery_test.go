@@ -2,9 +2,8 @@ package panic
 
 import (
 	"testing"
-	"time"
 
-	nsq "github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -16,58 +15,41 @@ func (r *_reporter) ReportPanic(err interface{}, stacktrace []byte) error {
 	return r.Called(err, nil).Error(0)
 }
 
-type _delegator struct {
-	mock.Mock
-}
-
-func (d *_delegator) OnFinish(*nsq.Message) {
-	d.Called()
-}
-
-func (d *_delegator) OnRequeue(*nsq.Message, time.Duration, bool) {
-	d.Called()
-}
-
-func (d *_delegator) OnTouch(*nsq.Message) {
-	d.Called()
-}
-
 func TestRecoverWithReporter(t *testing.T) {
 	// step 1. prepare testing variable
 	var (
-		reporter  = new(_reporter)
-		delegator = new(_delegator)
-		mw        = New(reporter)
-		f         = nsq.HandlerFunc(func(m *nsq.Message) error {
+		reporter = new(_reporter)
+		f        = func() (bool, error) {
 			panic("PANIC!!!")
-		})
+		}
 	)
 
 	// step 2. prepare mocking object (we dont need to test stacktrace because is will change for every execution)
-	reporter.On("ReportPanic", "PANIC!!!", nil).Return(nil)
-	delegator.On("OnRequeue").Once()
+	reporter.On("ReportPanic", "PANIC!!!", nil).Return(nil).Once()
 
 	// step 3. call the function wanted to test
-	mw(f).HandleMessage(&nsq.Message{
-		Delegate: delegator,
-	})
+	requeue, err := Recover(reporter, f)
+	assert.True(t, requeue)
+	assert.EqualError(t, err, "panic: PANIC!!!")
+	reporter.AssertExpectations(t)
 }
 
 func TestRecoverWithoutReporter(t *testing.T) {
 	// step 1. prepare testing variable
-	var (
-		mw        = New(nil)
-		delegator = new(_delegator)
-		f         = nsq.HandlerFunc(func(m *nsq.Message) error {
-			panic("PANIC")
-		})
-	)
+	f := func() (bool, error) {
+		panic("PANIC")
+	}
 
-	// step 2. prepare mocking object (we dont need to test stacktrace because is will change for every execution)
-	delegator.On("OnRequeue").Once()
+	// step 2. call the function wanted to test
+	requeue, err := Recover(nil, f)
+	assert.True(t, requeue)
+	assert.EqualError(t, err, "panic: PANIC")
+}
 
-	// step 3. call the function wanted to test
-	mw(f).HandleMessage(&nsq.Message{
-		Delegate: delegator,
+func TestRecoverWithoutPanic(t *testing.T) {
+	requeue, err := Recover(nil, func() (bool, error) {
+		return false, nil
 	})
+	assert.False(t, requeue)
+	assert.NoError(t, err)
 }
@@ -0,0 +1,71 @@
+package nsqconsumer
+
+import (
+	"context"
+	"reflect"
+
+	deduplicator "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/internal/deduplicator"
+	panicrecover "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/internal/panic"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// Message is the message passed through the middleware chain
+// Headers is the headers of the envelope, nil if the message is not enveloped
+// Payload is the decoded and validated body, which is the pointer passed to Handle
+type Message struct {
+	*nsq.Message
+
+	Topic   string
+	Channel string
+	Tags    map[string]interface{}
+	Headers nsqenvelope.Headers
+	Payload interface{}
+
+	// handle is the Handle method or the route of Router called with Payload
+	handle reflect.Value
+}
+
+// MessageHandler processes the message, it returns the requeue and error like the Handle of the Handler
+type MessageHandler func(ctx context.Context, msg *Message) (bool, error)
+
+// Middleware wraps the MessageHandler to run before and after the next handler
+type Middleware func(next MessageHandler) MessageHandler
+
+// Use adds the middlewares to every handler of the consumer. It must be called before Run or NewHarness.
+// The message goes through the panic recovery, the middlewares in the order they are added,
+// the deduplication (if ConsumerConfig.Deduplicator is enabled), then the Handle
+func (c *Consumer) Use(mw ...Middleware) {
+	c.middlewares = append(c.middlewares, mw...)
+}
+
+// Recovery recovers the panic raised by the next handler, reports it using rp, and requeues the message
+func Recovery(rp panicrecover.Reporter) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg *Message) (bool, error) {
+			return panicrecover.Recover(rp, func() (bool, error) {
+				return next(ctx, msg)
+			})
+		}
+	}
+}
+
+// deduplication skips the message which has been processed by the next handler
+func deduplication(d *deduplicator.Deduplicator) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg *Message) (bool, error) {
+			return d.Handle(msg.Topic, msg.Channel, msg.Message, func() (bool, error) {
+				return next(ctx, msg)
+			})
+		}
+	}
+}
+
+// chain builds the MessageHandler in which mws are called in order before h
+func chain(h MessageHandler, mws ...Middleware) MessageHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
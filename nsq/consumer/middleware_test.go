@@ -0,0 +1,94 @@
+package nsqconsumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumer_Use(t *testing.T) {
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(&ConsumerConfig{
+		Name:         "HARNESS_CHANNEL",
+		Topics:       []Topic{{Name: "HARNESS_TOPIC", Tags: map[string]interface{}{"source": "harness"}}},
+		Deduplicator: true,
+	})
+
+	var (
+		hh       = new(harnessHandler)
+		consumer = NewConsumer(cfg)
+		calls    []string
+	)
+	consumer.SetStorage(new(memoryStorage))
+	consumer.RegisterHandler(hh)
+	consumer.Use(
+		func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg *Message) (bool, error) {
+				calls = append(calls, "first:"+msg.Topic+":"+msg.Channel)
+				return next(ctx, msg)
+			}
+		},
+		func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg *Message) (bool, error) {
+				in := msg.Payload.(*harnessPayload)
+				calls = append(calls, "second:"+in.Mode)
+				if in.Mode == "skip" {
+					return false, errors.New("SKIPPED")
+				}
+				return next(ctx, msg)
+			}
+		},
+	)
+	h := NewHarness(consumer)
+
+	t.Run("Order", func(t *testing.T) {
+		d := h.Publish("HARNESS_TOPIC", []byte(`{"id": 1, "mode": "ok"}`))
+		assert.True(t, d[0].Finished)
+		assert.Equal(t, []string{"first:HARNESS_TOPIC:HARNESS_CHANNEL", "second:ok"}, calls)
+		assert.Equal(t, 1, hh.calls)
+	})
+
+	t.Run("ShortCircuit", func(t *testing.T) {
+		calls = nil
+		d := h.Publish("HARNESS_TOPIC", []byte(`{"id": 2, "mode": "skip"}`))
+		assert.True(t, d[0].Finished)
+		assert.Equal(t, []string{"first:HARNESS_TOPIC:HARNESS_CHANNEL", "second:skip"}, calls)
+		assert.Equal(t, 1, hh.calls)
+	})
+
+	t.Run("DeduplicationAfterMiddlewares", func(t *testing.T) {
+		calls = nil
+		d := h.Publish("HARNESS_TOPIC", []byte(`{"id": 1, "mode": "ok"}`))
+		assert.True(t, d[0].Finished)
+		assert.Len(t, calls, 2)
+		assert.Equal(t, 1, hh.calls)
+	})
+
+	t.Run("RecoveryWrapsMiddlewares", func(t *testing.T) {
+		d := h.Publish("HARNESS_TOPIC", []byte(`{"id": 3, "mode": "panic"}`))
+		assert.True(t, d[0].Requeued)
+		assert.EqualError(t, d[0].Err, "panic: HARNESS_PANIC")
+	})
+}
+
+func Test_chain(t *testing.T) {
+	var calls []int
+	mw := func(i int) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg *Message) (bool, error) {
+				calls = append(calls, i)
+				return next(ctx, msg)
+			}
+		}
+	}
+
+	requeue, err := chain(func(ctx context.Context, msg *Message) (bool, error) {
+		calls = append(calls, 0)
+		return true, nil
+	}, mw(1), mw(2), mw(3))(context.Background(), &Message{})
+	assert.True(t, requeue)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 0}, calls)
+}
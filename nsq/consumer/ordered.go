@@ -0,0 +1,121 @@
+package nsqconsumer
+
+import (
+	"hash/fnv"
+
+	panicrecover "devcode.xeemore.com/systech/gojunkyard/nsq/consumer/internal/panic"
+	reporter "devcode.xeemore.com/systech/gojunkyard/reporter"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// orderedHandler dispatches the message to the lane picked by the hash of its key (ConsumerConfig.OrderingKey).
+// Every lane is processed by a single worker, so the messages having the same key are processed serially
+// in the order they are received while the messages of different keys are processed concurrently.
+// It must be the only nsq handler of the consumer, otherwise the messages are dispatched out of order
+type orderedHandler struct {
+	handler  nsq.Handler
+	key      func(m *nsq.Message) ([]byte, error)
+	reporter reporter.Reporter
+	topic    string
+	channel  string
+	lanes    []chan *nsq.Message
+	done     chan struct{}
+}
+
+// newOrderedHandler creates orderedHandler with lanes workers calling h. Every lane buffers up to size messages
+func newOrderedHandler(
+	h nsq.Handler,
+	key func(m *nsq.Message) ([]byte, error),
+	reporter reporter.Reporter,
+	topic, channel string,
+	lanes, size int,
+) *orderedHandler {
+	if lanes < 1 {
+		lanes = 1
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	oh := &orderedHandler{
+		handler:  h,
+		key:      key,
+		reporter: reporter,
+		topic:    topic,
+		channel:  channel,
+		lanes:    make([]chan *nsq.Message, lanes),
+		done:     make(chan struct{}),
+	}
+	for i := range oh.lanes {
+		oh.lanes[i] = make(chan *nsq.Message, size)
+		go oh.work(oh.lanes[i])
+	}
+	return oh
+}
+
+// HandleMessage implements nsq.Handler. The message is responded by the worker of its lane
+func (oh *orderedHandler) HandleMessage(m *nsq.Message) error {
+	m.DisableAutoResponse()
+	select {
+	case oh.lanes[oh.lane(m)] <- m:
+	case <-oh.done:
+		m.Requeue(-1)
+	}
+	return nil
+}
+
+// lane returns the index of the lane of m. The whole body is hashed when the key cannot be extracted or
+// its extraction panics
+func (oh *orderedHandler) lane(m *nsq.Message) int {
+	var key []byte
+	_, err := panicrecover.Recover(oh.reporter, func() (bool, error) {
+		var err error
+		key, err = oh.key(m)
+		return false, err
+	})
+	if err != nil {
+		oh.reporter.Warningf(
+			"[NSQ] Consumer failed to extract the ordering key, using the whole message instead. topic: %s, channel: %s, message: %s, err: %s",
+			oh.topic, oh.channel, m.Body, err,
+		)
+		key = m.Body
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(len(oh.lanes)))
+}
+
+// work handles the messages of lane and responds them like the handler loop of nsq.Consumer
+func (oh *orderedHandler) work(lane chan *nsq.Message) {
+	for {
+		select {
+		case m := <-lane:
+			err := oh.handle(m)
+			if m.HasResponded() {
+				continue
+			}
+			if err != nil {
+				m.Requeue(-1)
+				continue
+			}
+			m.Finish()
+		case <-oh.done:
+			return
+		}
+	}
+}
+
+// handle calls the handler with m. The panic is recovered, so it does not stop the worker of the lane
+func (oh *orderedHandler) handle(m *nsq.Message) error {
+	_, err := panicrecover.Recover(oh.reporter, func() (bool, error) {
+		return false, oh.handler.HandleMessage(m)
+	})
+	return err
+}
+
+// close stops the workers. It is called after nsq.Consumer is stopped, so there is no more message to dispatch
+func (oh *orderedHandler) close() {
+	close(oh.done)
+}
@@ -0,0 +1,197 @@
+package nsqconsumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	nop_reporter "devcode.xeemore.com/systech/gojunkyard/reporter/nop"
+
+	nsq "github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+type orderedPayload struct {
+	UserID int `json:"user_id"`
+	Seq    int `json:"seq"`
+}
+
+type orderedHandlerMock struct {
+	mu      sync.Mutex
+	seqs    map[int][]int
+	running map[int]bool
+	overlap bool
+}
+
+func (oh *orderedHandlerMock) Name() string {
+	return "ORDERED_CHANNEL"
+}
+
+func (oh *orderedHandlerMock) Handle(ctx context.Context, tags map[string]interface{}, in *orderedPayload) (bool, error) {
+	oh.mu.Lock()
+	if oh.running[in.UserID] {
+		oh.overlap = true
+	}
+	oh.running[in.UserID] = true
+	oh.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	oh.mu.Lock()
+	oh.running[in.UserID] = false
+	oh.seqs[in.UserID] = append(oh.seqs[in.UserID], in.Seq)
+	oh.mu.Unlock()
+
+	if in.Seq < 0 {
+		return true, errors.New("ORDERED_RETRY")
+	}
+	return false, nil
+}
+
+func TestConsumerConfig_handlerConcurrency(t *testing.T) {
+	assert.Equal(t, 4, (&ConsumerConfig{Concurrency: 4}).handlerConcurrency(new(harnessHandler)))
+	assert.Equal(t, 1, (&ConsumerConfig{Concurrency: 4, OrderingKey: "id"}).handlerConcurrency(new(harnessHandler)))
+	assert.Equal(t, 10, (&ConsumerConfig{Concurrency: 4, OrderingKey: "id", BatchSize: 10}).handlerConcurrency(new(batchHandler)))
+}
+
+type orderedDelegate struct {
+	wg *sync.WaitGroup
+}
+
+func (od orderedDelegate) OnFinish(*nsq.Message) {
+	od.wg.Done()
+}
+
+func (od orderedDelegate) OnRequeue(*nsq.Message, time.Duration, bool) {
+	od.wg.Done()
+}
+
+func (od orderedDelegate) OnTouch(*nsq.Message) {}
+
+func Test_orderedHandler(t *testing.T) {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		order   = make(map[string][]int)
+		handler = nsq.HandlerFunc(func(m *nsq.Message) error {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			order[string(m.Body[:1])] = append(order[string(m.Body[:1])], int(m.Body[1]-'0'))
+			mu.Unlock()
+			return nil
+		})
+		key = func(m *nsq.Message) ([]byte, error) {
+			return m.Body[:1], nil
+		}
+		oh = newOrderedHandler(handler, key, nop_reporter.NewNopReporter(), "ORDERED_TOPIC", "ORDERED_CHANNEL", 4, 100)
+	)
+	defer oh.close()
+
+	for seq := 0; seq < 10; seq++ {
+		for _, user := range "abcdefgh" {
+			wg.Add(1)
+			m := nsq.NewMessage(nsq.MessageID{}, []byte(fmt.Sprintf("%c%d", user, seq)))
+			m.Delegate = orderedDelegate{wg: &wg}
+			assert.NoError(t, oh.HandleMessage(m))
+			assert.True(t, m.IsAutoResponseDisabled())
+		}
+	}
+	wg.Wait()
+
+	for _, user := range "abcdefgh" {
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, order[string(user)])
+	}
+}
+
+func Test_orderedHandler_lane(t *testing.T) {
+	rp := &recordingReporter{Reporter: nop_reporter.NewNopReporter()}
+	oh := newOrderedHandler(nil, func(m *nsq.Message) ([]byte, error) {
+		switch string(m.Body) {
+		case "invalid":
+			return nil, errors.New("INVALID")
+		case "panic":
+			panic("ORDERED_KEY_PANIC")
+		}
+		return m.Body, nil
+	}, rp, "ORDERED_TOPIC", "ORDERED_CHANNEL", 8, 1)
+	defer oh.close()
+
+	assert.Equal(t, oh.lane(nsq.NewMessage(nsq.MessageID{}, []byte("1"))), oh.lane(nsq.NewMessage(nsq.MessageID{}, []byte("1"))))
+	assert.True(t, oh.lane(nsq.NewMessage(nsq.MessageID{}, []byte("invalid"))) < 8)
+	assert.Len(t, rp.reports(), 1)
+	assert.True(t, oh.lane(nsq.NewMessage(nsq.MessageID{}, []byte("panic"))) < 8)
+	assert.Equal(t, "panic", rp.reports()[1].Level)
+}
+
+func Test_orderedHandler_panic(t *testing.T) {
+	var (
+		handler = nsq.HandlerFunc(func(m *nsq.Message) error {
+			if string(m.Body) == "panic" {
+				panic("ORDERED_PANIC")
+			}
+			return nil
+		})
+		key = func(m *nsq.Message) ([]byte, error) {
+			return nil, nil
+		}
+		rp = &recordingReporter{Reporter: nop_reporter.NewNopReporter()}
+		oh = newOrderedHandler(handler, key, rp, "ORDERED_TOPIC", "ORDERED_CHANNEL", 1, 1)
+	)
+	defer oh.close()
+
+	deliver := func(body string) Delivery {
+		var d Delivery
+		delegate := &harnessDelegate{delivery: &d, done: make(chan struct{})}
+		m := nsq.NewMessage(nsq.MessageID{}, []byte(body))
+		m.Delegate = delegate
+		assert.NoError(t, oh.HandleMessage(m))
+		<-delegate.done
+		return d
+	}
+
+	// the panic requeues the message and the worker of the lane keeps processing the next message
+	assert.True(t, deliver("panic").Requeued)
+	assert.Equal(t, "panic", rp.reports()[0].Level)
+	assert.True(t, deliver("ok").Finished)
+}
+
+func TestHarness_Ordered(t *testing.T) {
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(&ConsumerConfig{
+		Name:        "ORDERED_CHANNEL",
+		Topics:      []Topic{{Name: "ORDERED_TOPIC"}},
+		Concurrency: 4,
+		MaxInFlight: 100,
+		OrderingKey: "user_id",
+	})
+
+	oh := &orderedHandlerMock{seqs: make(map[int][]int), running: make(map[int]bool)}
+	consumer := NewConsumer(cfg)
+	consumer.RegisterHandler(oh)
+	h := NewHarness(consumer)
+	defer h.Close()
+
+	var wg sync.WaitGroup
+	for user := 0; user < 8; user++ {
+		wg.Add(1)
+		go func(user int) {
+			defer wg.Done()
+			for seq := 0; seq < 5; seq++ {
+				d := h.Publish("ORDERED_TOPIC", []byte(fmt.Sprintf(`{"user_id": %d, "seq": %d}`, user, seq)))
+				assert.True(t, d[0].Finished)
+			}
+		}(user)
+	}
+	wg.Wait()
+
+	assert.False(t, oh.overlap)
+	for user := 0; user < 8; user++ {
+		assert.Equal(t, []int{0, 1, 2, 3, 4}, oh.seqs[user])
+	}
+
+	d := h.Publish("ORDERED_TOPIC", []byte(`{"user_id": 1, "seq": -1}`))
+	assert.True(t, d[0].Requeued)
+}
@@ -0,0 +1,303 @@
+package nsqconsumer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// LoadConsumersFile reads the consumers from the json file at path. The format is the same as CONSUMERS env
+func LoadConsumersFile(path string) ([]*ConsumerConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var consumers consumerConfigs
+	if err := consumers.Decode(string(b)); err != nil {
+		return nil, fmt.Errorf("[NSQ] Failed to decode consumers. path: %s, err: %s", path, err)
+	}
+	return consumers, nil
+}
+
+// LoadConsumersEnv reads the consumers from the json env variable key, e.g. "CONSUMERS"
+func LoadConsumersEnv(key string) ([]*ConsumerConfig, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil, fmt.Errorf("[NSQ] Env variable is not found. key: %s", key)
+	}
+
+	var consumers consumerConfigs
+	if err := consumers.Decode(value); err != nil {
+		return nil, fmt.Errorf("[NSQ] Failed to decode consumers. key: %s, err: %s", key, err)
+	}
+	return consumers, nil
+}
+
+// reloadStep is the change of one topic and channel applied by Reload
+// sub: the subscription after the reload, it is the old subscription when the configuration is not changed
+// old: the running subscription replaced by sub, nil when the topic or channel is added
+// drained: old has been stopped before sub is started, see Reload
+type reloadStep struct {
+	cc      *ConsumerConfig
+	topic   Topic
+	handler Handler
+	sub     *subscription
+	old     *subscription
+	paused  bool
+	drained bool
+	err     error
+}
+
+// Reload applies consumers to the running consumer without restarting the process.
+// The subscription of the removed topic or channel is stopped after its in-flight messages are processed and
+// the subscription of the added one is started. MaxInFlight change is applied in place, and any other change,
+// e.g. the tags or concurrency, starts the new subscription before the old one is drained, so no message is dropped.
+// The ordered subscription (ConsumerConfig.OrderingKey) is the exception, the old one is drained first,
+// otherwise both of them would process the messages having the same key concurrently.
+// The new subscriptions connect to nsqlookupd and nsqd outside the lock, so Stats and the admin are not blocked.
+// The paused subscription keeps paused. Before Run, only the configuration is replaced
+func (c *Consumer) Reload(consumers []*ConsumerConfig) error {
+	if err := c.validateConsumers(consumers); err != nil {
+		return err
+	}
+
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	// step 1. compare the configuration with the running subscriptions
+	c.mu.Lock()
+	if c.ctx == nil || c.ctx.Err() != nil {
+		c.config.Consumers = consumers
+		c.mu.Unlock()
+		return nil
+	}
+
+	var (
+		current = make(map[string]*subscription, len(c.consumers))
+		steps   = make([]*reloadStep, 0, len(c.consumers))
+		stopped = make([]*subscription, 0)
+		errs    = make([]string, 0)
+	)
+	for _, v := range c.consumers {
+		current[v.channel+"/"+v.topic] = v
+	}
+
+	for _, cc := range consumers {
+		h, ok := c.handlers[cc.Name]
+		if !ok {
+			c.reporter.Infof("[NSQ] Handler.Handle: %s is ignored due to not exist.\n", cc.Name)
+			continue
+		}
+
+		for _, topic := range cc.Topics {
+			key := cc.Name + "/" + topic.Name
+			old, exists := current[key]
+			delete(current, key)
+
+			if exists && !old.changed(cc, topic) {
+				if old.config.MaxInFlight != cc.MaxInFlight {
+					old.maxInFlight = cc.MaxInFlight
+					if !old.paused {
+						old.consumer.ChangeMaxInFlight(cc.MaxInFlight)
+					}
+					c.reporter.Infof(
+						"[NSQ] Consumer changed max in flight. topic: %s, channel: %s, max in flight: %d",
+						topic.Name, cc.Name, cc.MaxInFlight,
+					)
+				}
+				old.config, old.topicConfig = cc, topic
+				steps = append(steps, &reloadStep{sub: old})
+				continue
+			}
+
+			step := &reloadStep{cc: cc, topic: topic, handler: h}
+			if exists {
+				step.old, step.paused = old, old.paused
+				step.drained = cc.OrderingKey != "" || old.config.OrderingKey != ""
+			}
+			steps = append(steps, step)
+		}
+	}
+
+	for _, v := range current {
+		stopped = append(stopped, v)
+		c.reporter.Infof("[NSQ] Consumer stopped due to removed from config. topic: %s, channel: %s", v.topic, v.channel)
+	}
+	c.mu.Unlock()
+
+	// step 2. start the new subscriptions outside the lock
+	for _, step := range steps {
+		if step.cc == nil {
+			continue
+		}
+		if step.drained {
+			step.old.stop()
+		}
+
+		step.sub, step.err = c.subscribe(step.cc, step.topic, step.handler)
+		if step.err == nil && step.paused {
+			step.sub.paused = true
+			step.sub.consumer.ChangeMaxInFlight(0)
+		}
+		if step.err == nil {
+			step.err = c.connect(step.sub)
+		}
+	}
+
+	// step 3. swap the subscriptions
+	c.mu.Lock()
+	next := make([]*subscription, 0, len(steps))
+	for _, step := range steps {
+		switch {
+		case step.cc == nil:
+			next = append(next, step.sub)
+			continue
+		case step.err != nil:
+			errs = append(errs, fmt.Sprintf("topic: %s, channel: %s, err: %s", step.topic.Name, step.cc.Name, step.err))
+			if step.sub != nil {
+				stopped = append(stopped, step.sub)
+			}
+			if step.old != nil && !step.drained {
+				next = append(next, step.old)
+			}
+			continue
+		case c.ctx.Err() != nil:
+			// the consumer is stopped while the new subscription is starting
+			stopped = append(stopped, step.sub)
+			continue
+		}
+
+		if step.old == nil {
+			c.reporter.Infof("[NSQ] Consumer started. topic: %s, channel: %s", step.topic.Name, step.cc.Name)
+			next = append(next, step.sub)
+			continue
+		}
+
+		// the old subscription may be paused or resumed while the new one is starting
+		if step.sub.paused != step.old.paused {
+			step.sub.paused = step.old.paused
+			if step.sub.paused {
+				step.sub.consumer.ChangeMaxInFlight(0)
+			} else {
+				step.sub.consumer.ChangeMaxInFlight(step.sub.maxInFlight)
+			}
+		}
+		if !step.drained {
+			stopped = append(stopped, step.old)
+		}
+		c.reporter.Infof("[NSQ] Consumer restarted due to config change. topic: %s, channel: %s", step.topic.Name, step.cc.Name)
+		next = append(next, step.sub)
+	}
+	if c.ctx.Err() == nil {
+		c.consumers = next
+	}
+	c.config.Consumers = consumers
+	c.mu.Unlock()
+
+	// the old subscriptions are drained outside the lock, so the admin and the next reload are not blocked
+	var wg sync.WaitGroup
+	for _, v := range stopped {
+		wg.Add(1)
+		go func(sub *subscription) {
+			defer wg.Done()
+			sub.stop()
+		}(v)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("[NSQ] Failed to reload consumers. %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// WatchConsumersFile reloads the consumers from the json file at path whenever its content changes.
+// The file is checked every interval until the returned function is called. The content at the time of
+// the call is taken as the current configuration
+func (c *Consumer) WatchConsumersFile(path string, interval time.Duration) (stop func()) {
+	var (
+		last, _ = ioutil.ReadFile(path)
+		done    = make(chan struct{})
+		once    sync.Once
+	)
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				c.reporter.Errorf("[NSQ] Failed to read consumers file. path: %s, err: %s", path, err)
+				continue
+			}
+			if bytes.Equal(b, last) {
+				continue
+			}
+			last = b
+
+			consumers, err := LoadConsumersFile(path)
+			if err == nil {
+				err = c.Reload(consumers)
+			}
+			if err != nil {
+				c.reporter.Errorf("[NSQ] Failed to reload consumers file. path: %s, err: %s", path, err)
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
+// changed reports whether cc and topic differ from the configuration of the subscription in anything other
+// than MaxInFlight, which can be changed without restarting the subscription
+func (s *subscription) changed(cc *ConsumerConfig, topic Topic) bool {
+	a, b := *s.config, *cc
+	a.Topics, b.Topics = nil, nil
+	a.MaxInFlight, b.MaxInFlight = 0, 0
+	return !reflect.DeepEqual(a, b) || !reflect.DeepEqual(s.topicConfig, topic)
+}
+
+// validateConsumers checks the names and codecs of consumers and their handlers before any of them is applied
+func (c *Consumer) validateConsumers(consumers []*ConsumerConfig) error {
+	for _, cc := range consumers {
+		if !nsq.IsValidChannelName(cc.Name) {
+			return fmt.Errorf("[NSQ] Invalid channel name. channel: %s", cc.Name)
+		}
+		for _, topic := range cc.Topics {
+			if !nsq.IsValidTopicName(topic.Name) {
+				return fmt.Errorf("[NSQ] Invalid topic name. topic: %s, channel: %s", topic.Name, cc.Name)
+			}
+			if _, err := nsqcodec.Get(topic.Codec); err != nil {
+				return fmt.Errorf("[NSQ] Invalid codec. topic: %s, channel: %s, err: %s", topic.Name, cc.Name, err)
+			}
+			h, ok := c.handlers[cc.Name]
+			if !ok {
+				continue
+			}
+			if err := validateHandler(cc, topic, h); err != nil {
+				return fmt.Errorf("[NSQ] Invalid handler. topic: %s, channel: %s, err: %s", topic.Name, cc.Name, err)
+			}
+		}
+	}
+	return nil
+}
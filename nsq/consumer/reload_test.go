@@ -0,0 +1,179 @@
+package nsqconsumer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const reloadTestConsumers = `[{"name": "HARNESS_CHANNEL", "topics": ["HARNESS_TOPIC_1", {"name": "HARNESS_TOPIC_3", "tags": {"source": "harness"}}], "maxInFlight": 10, "concurrency": 1}]`
+
+func decodeTestConsumers(t *testing.T, value string) []*ConsumerConfig {
+	var consumers consumerConfigs
+	if err := consumers.Decode(value); err != nil {
+		t.Fatal(err)
+	}
+	return consumers
+}
+
+func TestLoadConsumers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nsqconsumer")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "consumers.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(reloadTestConsumers), 0644))
+
+	consumers, err := LoadConsumersFile(path)
+	assert.Nil(t, err)
+	assert.Len(t, consumers, 1)
+	assert.Equal(t, []Topic{{Name: "HARNESS_TOPIC_1"}, {Name: "HARNESS_TOPIC_3", Tags: map[string]interface{}{"source": "harness"}}}, consumers[0].Topics)
+
+	_, err = LoadConsumersFile(filepath.Join(dir, "unknown.json"))
+	assert.NotNil(t, err)
+
+	os.Setenv("NSQ_RELOAD_TEST_CONSUMERS", reloadTestConsumers)
+	defer os.Unsetenv("NSQ_RELOAD_TEST_CONSUMERS")
+	consumers, err = LoadConsumersEnv("NSQ_RELOAD_TEST_CONSUMERS")
+	assert.Nil(t, err)
+	assert.Equal(t, 10, consumers[0].MaxInFlight)
+
+	_, err = LoadConsumersEnv("NSQ_RELOAD_TEST_UNKNOWN")
+	assert.NotNil(t, err)
+}
+
+func TestConsumer_Reload(t *testing.T) {
+	consumer := newAdminTestConsumer()
+	defer consumer.Stop()
+
+	consumer.mu.RLock()
+	topic1, topic2 := consumer.consumers[0], consumer.consumers[1]
+	consumer.mu.RUnlock()
+	assert.Nil(t, consumer.Pause("HARNESS_TOPIC_1", "HARNESS_CHANNEL"))
+
+	// invalid configuration is rejected without touching the running consumers
+	assert.NotNil(t, consumer.Reload([]*ConsumerConfig{{Name: "HARNESS_CHANNEL", Topics: []Topic{{Name: "HARNESS TOPIC"}}}}))
+	assert.NotNil(t, consumer.Reload([]*ConsumerConfig{{Name: "HARNESS_CHANNEL", Topics: []Topic{{Name: "HARNESS_TOPIC_1", Codec: "xml"}}}}))
+	assert.EqualError(t, consumer.Reload([]*ConsumerConfig{{
+		Name:        "HARNESS_CHANNEL",
+		Topics:      []Topic{{Name: "HARNESS_TOPIC_1", Codec: "protobuf"}},
+		OrderingKey: "id",
+	}}), `[NSQ] Invalid handler. topic: HARNESS_TOPIC_1, channel: HARNESS_CHANNEL, err: codec protobuf cannot decode the payload to look up the ordering key "id"`)
+	assert.Len(t, consumer.Stats(), 2)
+
+	t.Run("MaxInFlight", func(t *testing.T) {
+		assert.Nil(t, consumer.Reload([]*ConsumerConfig{{
+			Name:        "HARNESS_CHANNEL",
+			Topics:      []Topic{{Name: "HARNESS_TOPIC_1"}, {Name: "HARNESS_TOPIC_2"}},
+			MaxInFlight: 20,
+			Concurrency: 1,
+		}}))
+
+		consumer.mu.RLock()
+		assert.Same(t, topic1, consumer.consumers[0])
+		assert.Same(t, topic2, consumer.consumers[1])
+		consumer.mu.RUnlock()
+
+		stats := consumer.Stats()
+		assert.Equal(t, Stats{Topic: "HARNESS_TOPIC_1", Channel: "HARNESS_CHANNEL", MaxInFlight: 20, Paused: true}, stats[0])
+		assert.Equal(t, Stats{Topic: "HARNESS_TOPIC_2", Channel: "HARNESS_CHANNEL", MaxInFlight: 20}, stats[1])
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		consumers := decodeTestConsumers(t, reloadTestConsumers)
+		consumers[0].Concurrency = 2
+		assert.Nil(t, consumer.Reload(consumers))
+
+		// HARNESS_TOPIC_1 is restarted due to the concurrency change and keeps paused,
+		// HARNESS_TOPIC_2 is removed and HARNESS_TOPIC_3 is added
+		consumer.mu.RLock()
+		assert.NotSame(t, topic1, consumer.consumers[0])
+		consumer.mu.RUnlock()
+		<-topic1.consumer.StopChan
+		<-topic2.consumer.StopChan
+
+		stats := consumer.Stats()
+		assert.Len(t, stats, 2)
+		assert.Equal(t, Stats{Topic: "HARNESS_TOPIC_1", Channel: "HARNESS_CHANNEL", MaxInFlight: 10, Paused: true}, stats[0])
+		assert.Equal(t, Stats{Topic: "HARNESS_TOPIC_3", Channel: "HARNESS_CHANNEL", MaxInFlight: 10}, stats[1])
+		assert.Equal(t, consumers, []*ConsumerConfig(consumer.config.Consumers))
+	})
+
+	t.Run("Ordered", func(t *testing.T) {
+		consumer.mu.RLock()
+		old := consumer.consumers[0]
+		consumer.mu.RUnlock()
+
+		// the admin is served while the new subscriptions are starting
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 10; i++ {
+				consumer.Stats()
+			}
+		}()
+
+		consumers := decodeTestConsumers(t, reloadTestConsumers)
+		consumers[0].OrderingKey = "id"
+		assert.Nil(t, consumer.Reload(consumers))
+		<-done
+
+		// the old subscription of the ordered consumer is drained and replaced by the ordered one
+		select {
+		case <-old.consumer.StopChan:
+		default:
+			t.Error("old subscription is not drained")
+		}
+		consumer.mu.RLock()
+		_, ordered := consumer.consumers[0].handler.(*orderedHandler)
+		consumer.mu.RUnlock()
+		assert.True(t, ordered)
+		assert.True(t, consumer.Stats()[0].Paused)
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		assert.Nil(t, consumer.Reload(nil))
+		assert.Empty(t, consumer.Stats())
+	})
+}
+
+func TestConsumer_ReloadBeforeRun(t *testing.T) {
+	consumer := NewConsumer(NewConfig(nil, nil))
+	consumer.RegisterHandler(new(harnessHandler))
+
+	consumers := decodeTestConsumers(t, reloadTestConsumers)
+	assert.Nil(t, consumer.Reload(consumers))
+	assert.Empty(t, consumer.Stats())
+
+	consumer.init()
+	defer consumer.Stop()
+	assert.Len(t, consumer.Stats(), 2)
+}
+
+func TestConsumer_WatchConsumersFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nsqconsumer")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "consumers.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`[{"name": "HARNESS_CHANNEL", "topics": ["HARNESS_TOPIC_1", "HARNESS_TOPIC_2"], "maxInFlight": 10, "concurrency": 1}]`), 0644))
+
+	consumer := newAdminTestConsumer()
+	defer consumer.Stop()
+
+	stop := consumer.WatchConsumersFile(path, 10*time.Millisecond)
+	defer stop()
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(reloadTestConsumers), 0644))
+	assert.Eventually(t, func() bool {
+		stats := consumer.Stats()
+		return len(stats) == 2 && stats[1].Topic == "HARNESS_TOPIC_3"
+	}, time.Second, 10*time.Millisecond)
+
+	stop()
+	stop()
+}
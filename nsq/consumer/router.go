@@ -0,0 +1,169 @@
+package nsqconsumer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// UnknownTypeAction is what Router does with the message whose discriminator has no registered route
+type UnknownTypeAction int
+
+// List of UnknownTypeAction
+const (
+	// UnknownTypeDrop finishes the message and reports a warning
+	UnknownTypeDrop UnknownTypeAction = iota
+	// UnknownTypeRequeue requeues the message like the handler asking to be requeued, e.g. until the consumer knowing
+	// the type is deployed. It is published to ConsumerConfig.DeadLetterTopic when it reaches max attempts
+	UnknownTypeRequeue
+	// UnknownTypeDeadLetter publishes the message to ConsumerConfig.DeadLetterTopic immediately.
+	// The message is requeued when it cannot be published
+	UnknownTypeDeadLetter
+)
+
+// UnknownTypeError is the error of the message whose discriminator has no registered route
+type UnknownTypeError struct {
+	Field string
+	Type  string
+	Err   error
+}
+
+// Error implements error
+func (e *UnknownTypeError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("[NSQ] Router cannot find the type. field: %s, err: %s", e.Field, e.Err)
+	}
+	return fmt.Sprintf("[NSQ] Router has no route for the type. field: %s, type: %s", e.Field, e.Type)
+}
+
+// Router is the Handler which dispatches the messages of a channel to the typed handlers by the value of the
+// discriminator field, e.g. {"type": "order.created", ...}. The payload is decoded into the type of the routed
+// handler and validated like the payload of Handle. Router is registered by Consumer.RegisterHandler and
+// configured by ConsumerConfig like any other handler. Its topics have the same codec requirement as
+// ConsumerConfig.OrderingKey
+type Router struct {
+	name    string
+	field   string
+	fields  []string
+	unknown UnknownTypeAction
+	routes  map[string]route
+}
+
+// route is the typed handler registered for a discriminator value
+type route struct {
+	val  reflect.Value
+	elem reflect.Type
+}
+
+// NewRouter creates the router of channel name which reads the discriminator at the dot separated path field,
+// e.g. "type" or "meta.type". The message having unknown type is dropped by default, see Router.OnUnknown
+func NewRouter(name, field string) *Router {
+	return &Router{
+		name:   name,
+		field:  field,
+		fields: strings.Split(field, "."),
+		routes: make(map[string]route),
+	}
+}
+
+// Name returns the channel name
+func (r *Router) Name() string {
+	return r.name
+}
+
+// OnUnknown sets the action for the message having unknown type
+func (r *Router) OnUnknown(action UnknownTypeAction) *Router {
+	r.unknown = action
+	return r
+}
+
+// Route registers h handling the messages whose discriminator is typ.
+// h must be "func (ctx context.Context, tags map[string]interface{}, in *type) (bool, error)"
+func (r *Router) Route(typ string, h interface{}) *Router {
+	if _, ok := r.routes[typ]; ok {
+		panic(fmt.Sprintf("[NSQ] Router: %s has been routed type: %s", r.name, typ))
+	}
+
+	val := reflect.ValueOf(h)
+	ft := val.Type()
+	if ft.Kind() != reflect.Func ||
+		ft.NumIn() != 3 ||
+		ft.In(0) != reflect.TypeOf((*context.Context)(nil)).Elem() ||
+		ft.In(1) != reflect.TypeOf((*map[string]interface{})(nil)).Elem() ||
+		ft.In(2).Kind() != reflect.Ptr ||
+		ft.NumOut() != 2 ||
+		ft.Out(0).Kind() != reflect.Bool ||
+		ft.Out(1) != reflect.TypeOf((*error)(nil)).Elem() {
+		panic(`[NSQ] Router.Route handler must be "func (ctx context.Context, tags map[string]interface{}, in *type) (bool, error)`)
+	}
+
+	r.routes[typ] = route{val: val, elem: ft.In(2).Elem()}
+	return r
+}
+
+// decoder returns the function which peeks the discriminator of the body decoded by codec, then decodes the body
+// into the type of its route and returns it with the route handler.
+// *UnknownTypeError is returned when the discriminator is missing or has no route
+func (r *Router) decoder(codec nsqcodec.Codec) func(body []byte) (interface{}, reflect.Value, error) {
+	return func(body []byte) (interface{}, reflect.Value, error) {
+		var v interface{}
+		if err := decodeAny(codec, body, &v); err != nil {
+			return nil, reflect.Value{}, err
+		}
+
+		v, err := lookupField(v, r.field, r.fields)
+		if err != nil {
+			return nil, reflect.Value{}, &UnknownTypeError{Field: r.field, Err: err}
+		}
+		typ := fmt.Sprint(v)
+		rt, ok := r.routes[typ]
+		if !ok {
+			return nil, reflect.Value{}, &UnknownTypeError{Field: r.field, Type: typ}
+		}
+
+		in := reflect.New(rt.elem).Interface()
+		return in, rt.val, codec.Unmarshal(body, in)
+	}
+}
+
+// handleUnknownType applies action to the message m having unknown type. respond is used to requeue the message
+func (c *Consumer) handleUnknownType(
+	action UnknownTypeAction,
+	deadLetterTopic, topic, channel string,
+	m *nsq.Message,
+	err *UnknownTypeError,
+	respond func(m *nsq.Message, requeue bool, err error) error,
+) error {
+	switch action {
+	case UnknownTypeRequeue:
+		return respond(m, true, err)
+	case UnknownTypeDeadLetter:
+		dlErr := fmt.Errorf("[NSQ] dead letter topic is not set")
+		if deadLetterTopic != "" {
+			dlErr = c.publishDeadLetter(deadLetterTopic, topic, channel, m, err)
+		}
+		if dlErr == nil {
+			c.reporter.Errorf(
+				"[NSQ] Consumer published the message to dead letter due to unknown type. topic: %s, channel: %s, dead letter topic: %s, message: %s, err: %s",
+				topic, channel, deadLetterTopic, m.Body, err,
+			)
+			return nil
+		}
+		c.reporter.Errorf(
+			"[NSQ] Consumer failed publishing the message to dead letter. topic: %s, channel: %s, dead letter topic: %s, message: %s, err: %s",
+			topic, channel, deadLetterTopic, m.Body, dlErr,
+		)
+		return respond(m, true, err)
+	}
+
+	c.reporter.Warningf(
+		"[NSQ] Consumer drops the message due to unknown type. topic: %s, channel: %s, message: %s, err: %s",
+		topic, channel, m.Body, err,
+	)
+	return nil
+}
@@ -0,0 +1,157 @@
+package nsqconsumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type orderCreated struct {
+	ID    int64  `json:"id" validate:"required"`
+	Total int64  `json:"total"`
+	Type  string `json:"type"`
+}
+
+type orderCancelled struct {
+	ID     int64  `json:"id" validate:"required"`
+	Reason string `json:"reason"`
+}
+
+func newRouterHarness(action UnknownTypeAction, deadLetterTopic string) (*Harness, *[]interface{}) {
+	var handled []interface{}
+	router := NewRouter("ROUTER_CHANNEL", "type").
+		OnUnknown(action).
+		Route("order.created", func(ctx context.Context, tags map[string]interface{}, in *orderCreated) (bool, error) {
+			handled = append(handled, in)
+			return false, nil
+		}).
+		Route("order.cancelled", func(ctx context.Context, tags map[string]interface{}, in *orderCancelled) (bool, error) {
+			handled = append(handled, in)
+			if in.Reason == "retry" {
+				return true, errors.New("ROUTER_RETRY")
+			}
+			return false, nil
+		})
+
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(&ConsumerConfig{
+		Name:            "ROUTER_CHANNEL",
+		Topics:          []Topic{{Name: "ROUTER_TOPIC"}},
+		DeadLetterTopic: deadLetterTopic,
+	})
+	consumer := NewConsumer(cfg)
+	consumer.RegisterHandler(router)
+	return NewHarness(consumer), &handled
+}
+
+func TestRouter(t *testing.T) {
+	h, handled := newRouterHarness(UnknownTypeDrop, "")
+	defer h.Close()
+
+	d := h.Publish("ROUTER_TOPIC", []byte(`{"type": "order.created", "id": 1, "total": 100}`))
+	assert.True(t, d[0].Finished)
+	d = h.Publish("ROUTER_TOPIC", []byte(`{"type": "order.cancelled", "id": 1, "reason": "fraud"}`))
+	assert.True(t, d[0].Finished)
+	d = h.Publish("ROUTER_TOPIC", []byte(`{"type": "order.cancelled", "id": 2, "reason": "retry"}`))
+	assert.True(t, d[0].Requeued)
+	assert.Equal(t, []interface{}{
+		&orderCreated{ID: 1, Total: 100, Type: "order.created"},
+		&orderCancelled{ID: 1, Reason: "fraud"},
+		&orderCancelled{ID: 2, Reason: "retry"},
+	}, *handled)
+
+	// the routed payload is validated
+	d = h.Publish("ROUTER_TOPIC", []byte(`{"type": "order.created"}`))
+	assert.True(t, d[0].Finished)
+	assert.Len(t, *handled, 3)
+
+	// unknown and missing type are dropped
+	for _, body := range []string{`{"type": "order.shipped", "id": 1}`, `{"id": 1}`, `[1]`} {
+		d = h.Publish("ROUTER_TOPIC", []byte(body))
+		assert.True(t, d[0].Finished)
+		assert.Equal(t, "warning", h.Reports()[len(h.Reports())-1].Level)
+	}
+	assert.Len(t, *handled, 3)
+}
+
+func TestRouter_OnUnknown(t *testing.T) {
+	t.Run("Requeue", func(t *testing.T) {
+		h, _ := newRouterHarness(UnknownTypeRequeue, "ROUTER_DLQ")
+		defer h.Close()
+
+		d := h.PublishAndRetry("ROUTER_TOPIC", []byte(`{"type": "order.shipped", "id": 1}`))
+		assert.Len(t, d, 5)
+		assert.True(t, d[0].Requeued)
+		assert.True(t, d[4].Finished)
+		assert.Len(t, h.DeadLetters(), 1)
+	})
+
+	t.Run("DeadLetter", func(t *testing.T) {
+		h, _ := newRouterHarness(UnknownTypeDeadLetter, "ROUTER_DLQ")
+		defer h.Close()
+
+		d := h.Publish("ROUTER_TOPIC", []byte(`{"type": "order.shipped", "id": 1}`))
+		assert.True(t, d[0].Finished)
+
+		dls := h.DeadLetters()
+		assert.Len(t, dls, 1)
+		assert.Equal(t, "ROUTER_TOPIC", dls[0].Topic)
+		assert.Equal(t, uint16(1), dls[0].Attempts)
+		assert.Equal(t, "[NSQ] Router has no route for the type. field: type, type: order.shipped", dls[0].Error)
+	})
+
+	t.Run("DeadLetterWithoutTopic", func(t *testing.T) {
+		h, _ := newRouterHarness(UnknownTypeDeadLetter, "")
+		defer h.Close()
+
+		d := h.Publish("ROUTER_TOPIC", []byte(`{"type": "order.shipped", "id": 1}`))
+		assert.True(t, d[0].Requeued)
+	})
+}
+
+func TestRouter_Codec(t *testing.T) {
+	cfg := NewConfig(nil, nil)
+	cfg.AddConsumerConfig(&ConsumerConfig{
+		Name:   "ROUTER_CHANNEL",
+		Topics: []Topic{{Name: "ROUTER_TOPIC", Codec: "protobuf"}},
+	})
+	consumer := NewConsumer(cfg)
+	consumer.RegisterHandler(NewRouter("ROUTER_CHANNEL", "type"))
+
+	// protobuf payload cannot be decoded into the map to look up the discriminator
+	assert.Panics(t, func() { NewHarness(consumer) })
+	_, err := consumer.subscribe(cfg.Consumers[0], cfg.Consumers[0].Topics[0], consumer.handlers["ROUTER_CHANNEL"])
+	assert.EqualError(t, err, `codec protobuf cannot decode the payload to look up the router field "type"`)
+}
+
+func TestRouter_Route(t *testing.T) {
+	router := NewRouter("ROUTER_CHANNEL", "meta.type")
+	router.Route("created", func(ctx context.Context, tags map[string]interface{}, in *orderCreated) (bool, error) {
+		return false, nil
+	})
+
+	assert.Panics(t, func() {
+		router.Route("created", func(ctx context.Context, tags map[string]interface{}, in *orderCreated) (bool, error) {
+			return false, nil
+		})
+	})
+	assert.Panics(t, func() {
+		router.Route("cancelled", func(in *orderCancelled) error { return nil })
+	})
+
+	decode := router.decoder(nsqcodec.JSON)
+	in, call, err := decode([]byte(`{"meta": {"type": "created"}, "id": 1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, &orderCreated{ID: 1}, in)
+	assert.True(t, call.IsValid())
+
+	_, _, err = decode([]byte(`{"meta": "created"}`))
+	assert.IsType(t, &UnknownTypeError{}, err)
+	_, _, err = decode([]byte(`{`))
+	_, ok := err.(*UnknownTypeError)
+	assert.False(t, ok)
+}
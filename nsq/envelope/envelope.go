@@ -0,0 +1,137 @@
+package nsqenvelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	requestid "devcode.xeemore.com/systech/gojunkyard/middleware/requestid"
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// HeaderRequestID is the header carrying the request id of middleware/requestid
+const HeaderRequestID = "x-request-id"
+
+// magic prefixes the enveloped message body. Neither json, msgpack, protobuf nor gzip body starts with it,
+// so the plain body keeps working
+var magic = []byte{0x00, 'N', 'S', 'Q', 'E', 0x01}
+
+// ErrMalformed is returned by Decode when the body has the envelope prefix but cannot be unwrapped
+var ErrMalformed = errors.New("nsqenvelope: malformed envelope")
+
+// Headers is the metadata carried by the envelope along with the payload
+type Headers map[string]string
+
+// Set implements opentracing.TextMapWriter
+func (h Headers) Set(key, val string) {
+	h[key] = val
+}
+
+// ForeachKey implements opentracing.TextMapReader
+func (h Headers) ForeachKey(handler func(key, val string) error) error {
+	for k, v := range h {
+		if err := handler(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Envelope wraps Data with Headers. Publishing *Envelope using nsqproducer encodes Data with the codec of the topic
+// and prepends the headers, nsqconsumer unwraps it before decoding the payload
+type Envelope struct {
+	Headers Headers
+	Data    interface{}
+}
+
+// New creates the envelope of data carrying the request id and the span context of ctx
+func New(ctx context.Context, data interface{}) *Envelope {
+	e := &Envelope{Headers: make(Headers), Data: data}
+	if id := requestid.GetFromContext(ctx); id != "" {
+		e.Headers[HeaderRequestID] = id
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.Tracer().Inject(span.Context(), opentracing.TextMap, e.Headers)
+	}
+	return e
+}
+
+// WithHeader sets the custom header and returns the envelope
+func (e *Envelope) WithHeader(key, val string) *Envelope {
+	if e.Headers == nil {
+		e.Headers = make(Headers)
+	}
+	e.Headers[key] = val
+	return e
+}
+
+// Marshal encodes data using codec. Data is wrapped when it is *Envelope, and nsqcodec.Raw is returned as is
+func Marshal(codec nsqcodec.Codec, data interface{}) ([]byte, error) {
+	switch v := data.(type) {
+	case nsqcodec.Raw:
+		return v, nil
+	case *Envelope:
+		payload, err := Marshal(codec, v.Data)
+		if err != nil {
+			return nil, err
+		}
+		return Encode(v.Headers, payload)
+	}
+	return codec.Marshal(data)
+}
+
+// Encode wraps the encoded payload with headers. The layout is the magic prefix, big endian uint32 length of
+// the json encoded headers, the headers and the payload
+func Encode(headers Headers, payload []byte) ([]byte, error) {
+	h, err := json.Marshal(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, len(magic)+4+len(h)+len(payload))
+	b = append(b, magic...)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(magic):], uint32(len(h)))
+	b = append(b, h...)
+	return append(b, payload...), nil
+}
+
+// Decode unwraps body into its headers and payload. The plain body is returned as is with nil headers
+func Decode(body []byte) (Headers, []byte, error) {
+	if !IsEnvelope(body) {
+		return nil, body, nil
+	}
+
+	b := body[len(magic):]
+	if len(b) < 4 {
+		return nil, nil, ErrMalformed
+	}
+	n := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	if uint64(n) > uint64(len(b)) {
+		return nil, nil, ErrMalformed
+	}
+
+	var headers Headers
+	if err := json.Unmarshal(b[:n], &headers); err != nil {
+		return nil, nil, ErrMalformed
+	}
+	if headers == nil {
+		headers = make(Headers)
+	}
+	return headers, b[n:], nil
+}
+
+// IsEnvelope reports whether body is wrapped by Encode
+func IsEnvelope(body []byte) bool {
+	return bytes.HasPrefix(body, magic)
+}
+
+// SpanContext extracts the span context injected by New from headers using the global tracer
+func SpanContext(headers Headers) (opentracing.SpanContext, error) {
+	return opentracing.GlobalTracer().Extract(opentracing.TextMap, headers)
+}
@@ -0,0 +1,78 @@
+package nsqenvelope
+
+import (
+	"context"
+	"testing"
+
+	requestid "devcode.xeemore.com/systech/gojunkyard/middleware/requestid"
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	body, err := Encode(Headers{"tenant": "xeemore"}, []byte(`{"id":1}`))
+	assert.Nil(t, err)
+	assert.True(t, IsEnvelope(body))
+
+	headers, payload, err := Decode(body)
+	assert.Nil(t, err)
+	assert.Equal(t, Headers{"tenant": "xeemore"}, headers)
+	assert.Equal(t, `{"id":1}`, string(payload))
+
+	// plain body is returned as is
+	headers, payload, err = Decode([]byte(`{"id":1}`))
+	assert.Nil(t, err)
+	assert.Nil(t, headers)
+	assert.Equal(t, `{"id":1}`, string(payload))
+
+	// malformed envelope
+	for _, b := range [][]byte{magic, body[:len(magic)+5], append(append([]byte{}, magic...), 0, 0, 0, 2, '{', '1')} {
+		_, _, err = Decode(b)
+		assert.Equal(t, ErrMalformed, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	span := tracer.StartSpan("http.request")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	ctx = requestid.NewContext(ctx, "0685d19c7a3741f09369271af0750eed")
+
+	e := New(ctx, map[string]int{"id": 1}).WithHeader("tenant", "xeemore")
+	assert.Equal(t, "0685d19c7a3741f09369271af0750eed", e.Headers[HeaderRequestID])
+	assert.Equal(t, "xeemore", e.Headers["tenant"])
+
+	spanCtx, err := SpanContext(e.Headers)
+	assert.Nil(t, err)
+	assert.Equal(t, span.Context().(mocktracer.MockSpanContext).SpanID, spanCtx.(mocktracer.MockSpanContext).SpanID)
+
+	// without request id and span
+	e = New(context.Background(), nil)
+	assert.Empty(t, e.Headers)
+}
+
+func TestMarshal(t *testing.T) {
+	b, err := Marshal(nsqcodec.JSON, map[string]int{"id": 1})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":1}`, string(b))
+
+	b, err = Marshal(nsqcodec.JSON, (&Envelope{Data: map[string]int{"id": 1}}).WithHeader("tenant", "xeemore"))
+	assert.Nil(t, err)
+	headers, payload, err := Decode(b)
+	assert.Nil(t, err)
+	assert.Equal(t, Headers{"tenant": "xeemore"}, headers)
+	assert.Equal(t, `{"id":1}`, string(payload))
+
+	b, err = Marshal(nsqcodec.Msgpack, nsqcodec.Raw(`{"id":1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":1}`, string(b))
+
+	_, err = Marshal(nsqcodec.JSON, &Envelope{Data: make(chan int)})
+	assert.NotNil(t, err)
+}
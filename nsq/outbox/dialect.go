@@ -0,0 +1,90 @@
+package nsqoutbox
+
+import "fmt"
+
+// dialect holds the queries of the outbox table for a database dialect.
+// The queries use "?" placeholder which is rebound by sqlx to the placeholder of the dialect
+type dialect struct {
+	migrations []string
+	insert     string
+	pending    string
+	sent       string
+	failed     string
+	purge      string
+}
+
+func newDialect(name, table string, limit int) (*dialect, error) {
+	d := &dialect{
+		insert: fmt.Sprintf("INSERT INTO %s (topic, body, delay_ms, attempts, created_at, retry_at) VALUES (?, ?, ?, 0, ?, ?)", table),
+		sent:   fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, sent_at = ? WHERE id = ?", table),
+		failed: fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = ?, retry_at = ? WHERE id = ?", table),
+		purge:  fmt.Sprintf("DELETE FROM %s WHERE sent_at IS NOT NULL AND sent_at < ?", table),
+	}
+
+	switch name {
+	case "mysql":
+		// SKIP LOCKED requires MySQL 8.0
+		d.migrations = []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	topic VARCHAR(255) NOT NULL,
+	body LONGBLOB NOT NULL,
+	delay_ms BIGINT NOT NULL DEFAULT 0,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NULL,
+	created_at DATETIME(6) NOT NULL,
+	retry_at DATETIME(6) NOT NULL,
+	sent_at DATETIME(6) NULL,
+	INDEX idx_%s_pending (sent_at, retry_at)
+)`, table, table),
+		}
+		d.pending = fmt.Sprintf(
+			"SELECT id, topic, body, delay_ms, attempts, created_at FROM %s WHERE sent_at IS NULL AND attempts < ? AND retry_at <= ? ORDER BY id LIMIT %d FOR UPDATE SKIP LOCKED",
+			table, limit,
+		)
+	case "postgres":
+		d.migrations = []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	topic VARCHAR(255) NOT NULL,
+	body BYTEA NOT NULL,
+	delay_ms BIGINT NOT NULL DEFAULT 0,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NULL,
+	created_at TIMESTAMP NOT NULL,
+	retry_at TIMESTAMP NOT NULL,
+	sent_at TIMESTAMP NULL
+)`, table),
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_pending ON %s (sent_at, retry_at)", table, table),
+		}
+		d.pending = fmt.Sprintf(
+			"SELECT id, topic, body, delay_ms, attempts, created_at FROM %s WHERE sent_at IS NULL AND attempts < ? AND retry_at <= ? ORDER BY id LIMIT %d FOR UPDATE SKIP LOCKED",
+			table, limit,
+		)
+	case "sqlserver":
+		d.migrations = []string{
+			fmt.Sprintf(`IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s (
+	id BIGINT IDENTITY(1,1) PRIMARY KEY,
+	topic NVARCHAR(255) NOT NULL,
+	body VARBINARY(MAX) NOT NULL,
+	delay_ms BIGINT NOT NULL DEFAULT 0,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error NVARCHAR(MAX) NULL,
+	created_at DATETIME2 NOT NULL,
+	retry_at DATETIME2 NOT NULL,
+	sent_at DATETIME2 NULL
+)`, table, table),
+			fmt.Sprintf(
+				"IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = N'idx_%s_pending') CREATE INDEX idx_%s_pending ON %s (sent_at, retry_at)",
+				table, table, table,
+			),
+		}
+		d.pending = fmt.Sprintf(
+			"SELECT TOP (%d) id, topic, body, delay_ms, attempts, created_at FROM %s WITH (UPDLOCK, READPAST, ROWLOCK) WHERE sent_at IS NULL AND attempts < ? AND retry_at <= ? ORDER BY id",
+			limit, table,
+		)
+	default:
+		return nil, fmt.Errorf("Dialect is not supported. expected: (mysql|postgres|sqlserver), got: %s", name)
+	}
+	return d, nil
+}
@@ -0,0 +1,303 @@
+package nsqoutbox
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+	nsqproducer "devcode.xeemore.com/systech/gojunkyard/nsq/producer"
+	"devcode.xeemore.com/systech/gojunkyard/reporter"
+	"devcode.xeemore.com/systech/gojunkyard/reporter/nop"
+	"devcode.xeemore.com/systech/gojunkyard/storage/mysql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrAsyncProducer is returned by New when the producer publishes asynchronously. Its publish returns before nsqd
+// receives the message, so the message could be marked sent and never be published
+var ErrAsyncProducer = errors.New("[NSQ_OUTBOX] async producer is not supported, use the sync producer instead")
+
+// Config holds the configuration of the outbox
+// Table: name of the outbox table, default is "nsq_outbox"
+// Codec: name of nsqcodec.Codec encoding the data written to the outbox, default is "json"
+// PollInterval: how often the relay looks for the pending messages, default is 1 second
+// BatchSize: max number of messages relayed in a transaction, default is 100
+// MaxAttempts: number of publish attempts before the message is given up and left unsent in the table, default is 10
+// RetryDelay: delay before the failed message is retried, doubled on every attempt up to MaxRetryDelay. Default is 1 second
+// MaxRetryDelay: max delay before the failed message is retried, default is 1 minute
+// Retention: how long the sent messages are kept in the table, zero keeps them forever
+type Config struct {
+	Table         string
+	Codec         string
+	PollInterval  time.Duration
+	BatchSize     int
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	Retention     time.Duration
+}
+
+// Outbox writes the messages into the outbox table within the transaction of the caller, so the messages are
+// only published when the transaction is committed. The relay publishes the pending messages using the producer
+// and marks them sent. The message is delivered at least once, the consumer may deduplicate it
+type Outbox struct {
+	db       *mysql.DB
+	producer nsqproducer.IProducer
+	reporter reporter.Reporter
+	config   Config
+	codec    nsqcodec.Codec
+	dialect  *dialect
+	now      func() time.Time
+
+	runOnce  sync.Once
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// row is the outbox message which is pending to be relayed
+type row struct {
+	ID        int64     `db:"id"`
+	Topic     string    `db:"topic"`
+	Body      []byte    `db:"body"`
+	DelayMS   int64     `db:"delay_ms"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// New creates the outbox stored in db using the dialect of db and relayed to producer.
+// The producer must publish synchronously, see ErrAsyncProducer
+func New(db *mysql.DB, producer nsqproducer.IProducer, cfg Config) (*Outbox, error) {
+	if nsqproducer.IsAsync(producer) {
+		return nil, ErrAsyncProducer
+	}
+	if cfg.Table == "" {
+		cfg.Table = "nsq_outbox"
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = time.Second
+	}
+	if cfg.MaxRetryDelay <= 0 {
+		cfg.MaxRetryDelay = time.Minute
+	}
+
+	codec, err := nsqcodec.Get(cfg.Codec)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newDialect(db.Dialect, cfg.Table, cfg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Outbox{
+		db:       db,
+		producer: producer,
+		reporter: nop.NewNopReporter(),
+		config:   cfg,
+		codec:    codec,
+		dialect:  d,
+		now:      time.Now,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}, nil
+}
+
+// SetReporter is used for report all data based on level
+func (o *Outbox) SetReporter(reporter reporter.Reporter) {
+	o.reporter = reporter
+}
+
+// Migrate creates the outbox table and its index if they do not exist
+func (o *Outbox) Migrate() error {
+	for _, query := range o.dialect.migrations {
+		if _, err := o.db.Client.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Publish writes data of topic into the outbox within tx
+func (o *Outbox) Publish(tx *sqlx.Tx, topic string, data interface{}) error {
+	return o.DeferredPublish(tx, topic, 0, data)
+}
+
+// MultiPublish writes multiple data of topic into the outbox within tx
+func (o *Outbox) MultiPublish(tx *sqlx.Tx, topic string, data []interface{}) error {
+	for _, v := range data {
+		if err := o.DeferredPublish(tx, topic, 0, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeferredPublish writes data of topic into the outbox within tx. The message is published with the delay
+// counted from now, the time spent in the outbox is deducted from the delay
+func (o *Outbox) DeferredPublish(tx *sqlx.Tx, topic string, delay time.Duration, data interface{}) error {
+	b, err := nsqenvelope.Marshal(o.codec, data)
+	if err != nil {
+		o.reporter.Errorf(
+			"[NSQ_OUTBOX] Failed marshaling data. topic: %s, message: %+v, err: %v",
+			topic, data, err,
+		)
+		return err
+	}
+
+	now := o.now().UTC()
+	_, err = tx.Exec(tx.Rebind(o.dialect.insert), topic, b, delay.Milliseconds(), now, now)
+	if err != nil {
+		o.reporter.Errorf(
+			"[NSQ_OUTBOX] Failed writing the message. topic: %s, message: %s, err: %v",
+			topic, b, err,
+		)
+		return err
+	}
+	return nil
+}
+
+// Run starts the relay goroutine publishing the pending messages every PollInterval
+func (o *Outbox) Run() {
+	o.runOnce.Do(func() {
+		go o.loop()
+	})
+}
+
+// Stop stops the relay and waits for the running relay to complete
+func (o *Outbox) Stop() {
+	o.stopOnce.Do(func() {
+		close(o.stopCh)
+	})
+
+	started := true
+	o.runOnce.Do(func() {
+		started = false
+	})
+	if started {
+		<-o.doneCh
+	}
+}
+
+func (o *Outbox) loop() {
+	defer close(o.doneCh)
+
+	t := time.NewTicker(o.config.PollInterval)
+	defer t.Stop()
+	for {
+		o.flush()
+		select {
+		case <-t.C:
+		case <-o.stopCh:
+			return
+		}
+	}
+}
+
+// flush relays the pending messages until there is no more message due, then purges the expired sent messages
+func (o *Outbox) flush() {
+	for {
+		n, err := o.relay()
+		if err != nil {
+			o.reporter.Errorf("[NSQ_OUTBOX] Failed relaying the messages. table: %s, err: %v", o.config.Table, err)
+			return
+		}
+		if n < o.config.BatchSize {
+			break
+		}
+
+		select {
+		case <-o.stopCh:
+			return
+		default:
+		}
+	}
+
+	if o.config.Retention > 0 {
+		_, err := o.db.Client.Exec(o.db.Client.Rebind(o.dialect.purge), o.now().UTC().Add(-o.config.Retention))
+		if err != nil {
+			o.reporter.Errorf("[NSQ_OUTBOX] Failed purging the sent messages. table: %s, err: %v", o.config.Table, err)
+		}
+	}
+}
+
+// relay publishes a batch of pending messages in a transaction locking them from the other relays.
+// It returns the number of messages which have been tried
+func (o *Outbox) relay() (int, error) {
+	tx, err := o.db.Client.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var (
+		now  = o.now().UTC()
+		rows []row
+	)
+	err = tx.Select(&rows, tx.Rebind(o.dialect.pending), o.config.MaxAttempts, now)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, r := range rows {
+		err := o.publish(r, now)
+		if err == nil {
+			_, err = tx.Exec(tx.Rebind(o.dialect.sent), now, r.ID)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		attempts := r.Attempts + 1
+		if attempts >= o.config.MaxAttempts {
+			o.reporter.Errorf(
+				"[NSQ_OUTBOX] Giving up publishing the message due to reaching max attempts. id: %d, topic: %s, message: %s, err: %v",
+				r.ID, r.Topic, r.Body, err,
+			)
+		} else {
+			o.reporter.Warningf(
+				"[NSQ_OUTBOX] Failed publishing the message, it will be retried. id: %d, topic: %s, attempts: %d, message: %s, err: %v",
+				r.ID, r.Topic, attempts, r.Body, err,
+			)
+		}
+		_, err = tx.Exec(tx.Rebind(o.dialect.failed), err.Error(), now.Add(o.retryDelay(attempts)), r.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(rows), tx.Commit()
+}
+
+// publish publishes the message with the remaining delay
+func (o *Outbox) publish(r row, now time.Time) error {
+	delay := time.Duration(r.DelayMS)*time.Millisecond - now.Sub(r.CreatedAt)
+	if delay > 0 {
+		return o.producer.DeferredPublish(r.Topic, delay, nsqcodec.Raw(r.Body))
+	}
+	return o.producer.Publish(r.Topic, nsqcodec.Raw(r.Body))
+}
+
+// retryDelay returns RetryDelay doubled for every attempt, capped by MaxRetryDelay
+func (o *Outbox) retryDelay(attempts int) time.Duration {
+	delay := o.config.RetryDelay
+	for i := 1; i < attempts && delay < o.config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > o.config.MaxRetryDelay {
+		return o.config.MaxRetryDelay
+	}
+	return delay
+}
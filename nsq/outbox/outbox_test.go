@@ -0,0 +1,222 @@
+package nsqoutbox
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+	nsqproducer "devcode.xeemore.com/systech/gojunkyard/nsq/producer"
+	"devcode.xeemore.com/systech/gojunkyard/storage/mysql"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+var pendingColumns = []string{"id", "topic", "body", "delay_ms", "attempts", "created_at"}
+
+func newTestOutbox(t *testing.T, dialect string, cfg Config) (*Outbox, sqlmock.Sqlmock, *nsqproducer.MemoryProducer) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	producer := nsqproducer.NewMemoryProducer()
+	o, err := New(&mysql.DB{Dialect: dialect, Client: sqlx.NewDb(db, dialect)}, producer, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.now = func() time.Time {
+		return time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return o, mock, producer
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&mysql.DB{Dialect: "sqlite3"}, nil, Config{})
+	assert.NotNil(t, err)
+
+	_, err = New(&mysql.DB{Dialect: "mysql"}, nil, Config{Codec: "xml"})
+	assert.NotNil(t, err)
+
+	async := nsqproducer.NewProducer(&nsqproducer.Config{NSQD: "127.0.0.1:4150", IsAsync: true})
+	_, err = New(&mysql.DB{Dialect: "mysql"}, async, Config{})
+	assert.Equal(t, ErrAsyncProducer, err)
+
+	o, err := New(&mysql.DB{Dialect: "mysql"}, nil, Config{})
+	assert.Nil(t, err)
+	assert.Equal(t, Config{
+		Table:         "nsq_outbox",
+		PollInterval:  time.Second,
+		BatchSize:     100,
+		MaxAttempts:   10,
+		RetryDelay:    time.Second,
+		MaxRetryDelay: time.Minute,
+	}, o.config)
+}
+
+func Test_newDialect(t *testing.T) {
+	for _, name := range []string{"mysql", "postgres", "sqlserver"} {
+		d, err := newDialect(name, "events_outbox", 10)
+		assert.Nil(t, err)
+		assert.Contains(t, d.migrations[0], "events_outbox")
+		assert.Contains(t, d.pending, "10")
+	}
+
+	d, _ := newDialect("postgres", "nsq_outbox", 10)
+	assert.Contains(t, sqlx.Rebind(sqlx.BindType("postgres"), d.insert), "VALUES ($1, $2, $3, 0, $4, $5)")
+	assert.Contains(t, d.pending, "FOR UPDATE SKIP LOCKED")
+
+	d, _ = newDialect("sqlserver", "nsq_outbox", 10)
+	assert.Contains(t, sqlx.Rebind(sqlx.BindType("sqlserver"), d.sent), "sent_at = @p1 WHERE id = @p2")
+	assert.Contains(t, d.pending, "SELECT TOP (10)")
+	assert.Contains(t, d.pending, "READPAST")
+}
+
+func TestOutbox_Migrate(t *testing.T) {
+	o, mock, _ := newTestOutbox(t, "postgres", Config{})
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS nsq_outbox")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE INDEX IF NOT EXISTS idx_nsq_outbox_pending")).WillReturnError(errors.New("DENIED"))
+
+	assert.EqualError(t, o.Migrate(), "DENIED")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestOutbox_Publish(t *testing.T) {
+	o, mock, _ := newTestOutbox(t, "mysql", Config{})
+	now := o.now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO nsq_outbox (topic, body, delay_ms, attempts, created_at, retry_at) VALUES (?, ?, ?, 0, ?, ?)")).
+		WithArgs("TOPIC", []byte(`{"id":1}`), int64(0), now, now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO nsq_outbox").
+		WithArgs("TOPIC", []byte(`{"id":2}`), int64(0), now, now).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO nsq_outbox").
+		WithArgs("TOPIC", []byte(`{"id":3}`), int64(0), now, now).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec("INSERT INTO nsq_outbox").
+		WithArgs("DELAYED", sqlmock.AnyArg(), int64(60000), now, now).
+		WillReturnError(errors.New("DEADLOCK"))
+	mock.ExpectRollback()
+
+	tx, err := o.db.Client.Beginx()
+	assert.Nil(t, err)
+	assert.Nil(t, o.Publish(tx, "TOPIC", map[string]int{"id": 1}))
+	assert.Nil(t, o.MultiPublish(tx, "TOPIC", []interface{}{map[string]int{"id": 2}, map[string]int{"id": 3}}))
+	assert.EqualError(t, o.DeferredPublish(tx, "DELAYED", time.Minute, nsqenvelope.New(context.Background(), 1)), "DEADLOCK")
+	assert.NotNil(t, o.Publish(tx, "TOPIC", make(chan int)))
+	assert.Nil(t, tx.Rollback())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestOutbox_relay(t *testing.T) {
+	o, mock, producer := newTestOutbox(t, "mysql", Config{MaxAttempts: 3})
+	now := o.now()
+
+	t.Run("Sent", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, topic, body, delay_ms, attempts, created_at FROM nsq_outbox")).
+			WithArgs(3, now).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).
+				AddRow(1, "TOPIC", []byte(`{"id":1}`), 0, 0, now.Add(-time.Second)).
+				AddRow(2, "DELAYED", []byte(`{"id":2}`), 60000, 0, now.Add(-time.Second)))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE nsq_outbox SET attempts = attempts + 1, sent_at = ? WHERE id = ?")).
+			WithArgs(now, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE nsq_outbox SET attempts = attempts + 1, sent_at = ? WHERE id = ?")).
+			WithArgs(now, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		n, err := o.relay()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []nsqproducer.Message{
+			{Topic: "TOPIC", Data: nsqcodec.Raw(`{"id":1}`)},
+			{Topic: "DELAYED", Delay: 59 * time.Second, Data: nsqcodec.Raw(`{"id":2}`)},
+		}, producer.Messages())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		producer.SetError(errors.New("NSQD_DOWN"))
+		defer producer.SetError(nil)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, topic, body").
+			WithArgs(3, now).
+			WillReturnRows(sqlmock.NewRows(pendingColumns).
+				AddRow(3, "TOPIC", []byte(`{"id":3}`), 0, 0, now).
+				AddRow(4, "TOPIC", []byte(`{"id":4}`), 0, 2, now))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE nsq_outbox SET attempts = attempts + 1, last_error = ?, retry_at = ? WHERE id = ?")).
+			WithArgs("NSQD_DOWN", now.Add(time.Second), 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE nsq_outbox SET attempts = attempts + 1, last_error = ?, retry_at = ? WHERE id = ?")).
+			WithArgs("NSQD_DOWN", now.Add(4*time.Second), 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		n, err := o.relay()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("QueryError", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, topic, body").WillReturnError(errors.New("TIMEOUT"))
+		mock.ExpectRollback()
+
+		_, err := o.relay()
+		assert.EqualError(t, err, "TIMEOUT")
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOutbox_flush(t *testing.T) {
+	o, mock, _ := newTestOutbox(t, "sqlserver", Config{PollInterval: time.Hour, Retention: 24 * time.Hour})
+	now := o.now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT TOP (100) id, topic, body")).
+		WithArgs(10, now).
+		WillReturnRows(sqlmock.NewRows(pendingColumns))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM nsq_outbox WHERE sent_at IS NOT NULL AND sent_at < @p1")).
+		WithArgs(now.Add(-24 * time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+
+	o.flush()
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestOutbox_RunStop(t *testing.T) {
+	o, mock, _ := newTestOutbox(t, "mysql", Config{PollInterval: time.Hour})
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, topic, body").WillReturnRows(sqlmock.NewRows(pendingColumns))
+	mock.ExpectCommit()
+
+	o.Run()
+	o.Stop()
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	// Stop without Run does not block, and Run after Stop does nothing
+	o, _, _ = newTestOutbox(t, "mysql", Config{})
+	o.Stop()
+	o.Run()
+}
+
+func TestOutbox_retryDelay(t *testing.T) {
+	o := &Outbox{config: Config{RetryDelay: time.Second, MaxRetryDelay: 10 * time.Second}}
+	assert.Equal(t, time.Second, o.retryDelay(1))
+	assert.Equal(t, 2*time.Second, o.retryDelay(2))
+	assert.Equal(t, 8*time.Second, o.retryDelay(4))
+	assert.Equal(t, 10*time.Second, o.retryDelay(5))
+	assert.Equal(t, 10*time.Second, o.retryDelay(100))
+}
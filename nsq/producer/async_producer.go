@@ -1,16 +1,26 @@
 package nsqproducer
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	nsq "github.com/nsqio/go-nsq"
 )
 
+// ErrStopped is returned when publishing through the stopped producer
+var ErrStopped = errors.New("[NSQ] producer has been stopped")
+
 // AsyncProducer will handle all of producer and config asynchronous
 type AsyncProducer struct {
 	Producer
 	transaction chan *nsq.ProducerTransaction
+	done        chan struct{}
+
+	mu      sync.RWMutex
+	stopped bool
+	pending sync.WaitGroup
 }
 
 // Init is used for initialize producer
@@ -22,13 +32,58 @@ func (p *AsyncProducer) Init() {
 	}
 
 	p.producer = np
+	go p.drain()
+}
+
+// drain reads all of transaction result until the producer is stopped
+func (p *AsyncProducer) drain() {
+	defer close(p.done)
+	for t := range p.transaction {
+		p.handleTransaction(t)
+	}
+}
+
+// handleTransaction reports the failed transaction and calls the callback passed on publish
+func (p *AsyncProducer) handleTransaction(t *nsq.ProducerTransaction) {
+	defer p.pending.Done()
+
+	var (
+		topic, _    = t.Args[0].(string)
+		data        = t.Args[1]
+		callback, _ = t.Args[2].(func(error))
+	)
+	if t.Error != nil {
+		p.reporter.Errorf(
+			"[NSQ] Producer async failed publish data. topic: %s, message: %+v, err: %v",
+			topic, data, t.Error,
+		)
+	}
+	if callback != nil {
+		callback(t.Error)
+	}
+}
+
+// begin marks one transaction as pending, it returns false if the producer has been stopped
+func (p *AsyncProducer) begin() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return false
+	}
+	p.pending.Add(1)
+	return true
 }
 
 // MultiPublish will publish multiple data to certain topic in nsq asynchronously
 func (p *AsyncProducer) MultiPublish(topic string, data []interface{}) error {
+	return p.MultiPublishWithCallback(topic, data, nil)
+}
+
+// MultiPublishWithCallback is like MultiPublish, callback is called with the result from nsqd when it is not nil
+func (p *AsyncProducer) MultiPublishWithCallback(topic string, data []interface{}, callback func(error)) error {
 	bb := make([][]byte, 0, len(data))
 	for _, v := range data {
-		b, err := json.Marshal(v)
+		b, err := p.marshal(topic, v)
 		if err != nil {
 			p.reporter.Errorf(
 				"[NSQ] Producer async failed marshaling data. topic: %s, message: %+v, err: %v",
@@ -39,8 +94,12 @@ func (p *AsyncProducer) MultiPublish(topic string, data []interface{}) error {
 		bb = append(bb, b)
 	}
 
-	err := p.producer.MultiPublishAsync(topic, bb, p.transaction)
+	if !p.begin() {
+		return ErrStopped
+	}
+	err := p.producer.MultiPublishAsync(topic, bb, p.transaction, topic, data, callback)
 	if err != nil {
+		p.pending.Done()
 		p.reporter.Errorf(
 			"[NSQ] Producer async failed publish data. topic: %s, message: %+v, err: %v",
 			topic, data, err,
@@ -53,7 +112,12 @@ func (p *AsyncProducer) MultiPublish(topic string, data []interface{}) error {
 
 // Publish will publish data to certain topic in nsq asynchronously
 func (p *AsyncProducer) Publish(topic string, data interface{}) error {
-	b, err := json.Marshal(data)
+	return p.PublishWithCallback(topic, data, nil)
+}
+
+// PublishWithCallback is like Publish, callback is called with the result from nsqd when it is not nil
+func (p *AsyncProducer) PublishWithCallback(topic string, data interface{}, callback func(error)) error {
+	b, err := p.marshal(topic, data)
 	if err != nil {
 		p.reporter.Errorf(
 			"[NSQ] Producer async failed marshaling data. topic: %s, message: %+v, err: %v",
@@ -62,8 +126,12 @@ func (p *AsyncProducer) Publish(topic string, data interface{}) error {
 		return err
 	}
 
-	err = p.producer.PublishAsync(topic, b, p.transaction)
+	if !p.begin() {
+		return ErrStopped
+	}
+	err = p.producer.PublishAsync(topic, b, p.transaction, topic, data, callback)
 	if err != nil {
+		p.pending.Done()
 		p.reporter.Errorf(
 			"[NSQ] Producer async failed publish data. topic: %s, message: %+v, err: %v",
 			topic, data, err,
@@ -73,3 +141,55 @@ func (p *AsyncProducer) Publish(topic string, data interface{}) error {
 
 	return nil
 }
+
+// DeferredPublish will publish data to certain topic in nsq asynchronously which is delivered to the consumer after delay
+func (p *AsyncProducer) DeferredPublish(topic string, delay time.Duration, data interface{}) error {
+	return p.DeferredPublishWithCallback(topic, delay, data, nil)
+}
+
+// DeferredPublishWithCallback is like DeferredPublish, callback is called with the result from nsqd when it is not nil
+func (p *AsyncProducer) DeferredPublishWithCallback(topic string, delay time.Duration, data interface{}, callback func(error)) error {
+	b, err := p.marshal(topic, data)
+	if err != nil {
+		p.reporter.Errorf(
+			"[NSQ] Producer async failed marshaling data. topic: %s, message: %+v, err: %v",
+			topic, data, err,
+		)
+		return err
+	}
+
+	if !p.begin() {
+		return ErrStopped
+	}
+	err = p.producer.DeferredPublishAsync(topic, delay, b, p.transaction, topic, data, callback)
+	if err != nil {
+		p.pending.Done()
+		p.reporter.Errorf(
+			"[NSQ] Producer async failed deferred publish data. topic: %s, delay: %s, message: %+v, err: %v",
+			topic, delay, data, err,
+		)
+		return err
+	}
+
+	return nil
+}
+
+// Stop rejects the next publish, waits for all of outstanding transaction and disconnects the producer from nsqd
+func (p *AsyncProducer) Stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	p.mu.Unlock()
+
+	if p.producer == nil {
+		return
+	}
+
+	p.pending.Wait()
+	p.producer.Stop()
+	close(p.transaction)
+	<-p.done
+}
@@ -0,0 +1,59 @@
+package nsqproducer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncProducer(t *testing.T) {
+	nsqd := newFakeNSQD(t)
+
+	p := NewProducer(NewConfig(nsqd.Addr(), true)).(*AsyncProducer)
+	p.Init()
+
+	var (
+		wg      sync.WaitGroup
+		results []error
+		mu      sync.Mutex
+	)
+	callback := func(err error) {
+		mu.Lock()
+		results = append(results, err)
+		mu.Unlock()
+		wg.Done()
+	}
+
+	wg.Add(2)
+	assert.Nil(t, p.PublishWithCallback("TOPIC", map[string]int{"id": 1}, callback))
+	assert.Nil(t, p.MultiPublishWithCallback("TOPIC", []interface{}{1, 2}, callback))
+	assert.Nil(t, p.Publish("TOPIC", 3))
+	wg.Wait()
+	assert.Equal(t, []error{nil, nil}, results)
+
+	p.Stop()
+	assert.Equal(t, ErrStopped, p.Publish("TOPIC", 4))
+	assert.Equal(t, []string{"IDENTIFY", "PUB TOPIC", "MPUB TOPIC", "PUB TOPIC"}, nsqd.Commands())
+
+	// must not block nor panic when it is stopped twice
+	p.Stop()
+}
+
+func TestAsyncProducer_StopWithoutInit(t *testing.T) {
+	p := NewProducer(NewConfig("127.0.0.1:4150", true))
+	p.Stop()
+	assert.Equal(t, ErrStopped, p.Publish("TOPIC", 1))
+}
+
+func TestAsyncProducer_PublishFailed(t *testing.T) {
+	p := NewProducer(NewConfig("127.0.0.1:1", true))
+	p.Init()
+	defer p.Stop()
+
+	assert.NotNil(t, p.Publish("TOPIC", 1))
+	assert.NotNil(t, p.MultiPublish("TOPIC", []interface{}{1}))
+	assert.NotNil(t, p.Publish("TOPIC", func() {}))
+	assert.False(t, errors.Is(p.Publish("TOPIC", 1), ErrStopped))
+}
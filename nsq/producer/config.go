@@ -1,15 +1,38 @@
 package nsqproducer
 
+// List of balancing strategy between several nsqd
+const (
+	BalancingFailover   = "failover"
+	BalancingRoundRobin = "round-robin"
+)
+
 // Config holds all configuration of nsqd and asynchronous
+// NSQDs: several nsqd addresses, when it is filled NSQD is ignored and the data is published using Balancing strategy
+// Balancing: "failover" (default) keeps publishing to the same nsqd until it fails, "round-robin" rotates every publish
+// DefaultCodec: name of nsqcodec.Codec used to encode the published data, default is "json"
+// Codecs: codec name per topic which overrides DefaultCodec, e.g. "USER_CREATED:protobuf,AUDIT_LOG:gzip+json"
 type Config struct {
-	NSQD    string `envconfig:"NSQD"`
-	IsAsync bool   `envconfig:"IS_ASYNC"`
+	NSQD         string            `envconfig:"NSQD"`
+	NSQDs        []string          `envconfig:"NSQDS"`
+	Balancing    string            `envconfig:"BALANCING"`
+	IsAsync      bool              `envconfig:"IS_ASYNC"`
+	DefaultCodec string            `envconfig:"DEFAULT_CODEC"`
+	Codecs       map[string]string `envconfig:"CODECS"`
 }
 
 // NewConfig returns pointer of Config object
 func NewConfig(addr string, isAsync bool) *Config {
 	return &Config{
 		NSQD:    addr,
-		IsAsync: false, // there's a bug in async producer, so it's disabled
+		IsAsync: isAsync,
+	}
+}
+
+// NewMultiConfig returns pointer of Config object publishing to several nsqd
+func NewMultiConfig(addrs []string, balancing string, isAsync bool) *Config {
+	return &Config{
+		NSQDs:     addrs,
+		Balancing: balancing,
+		IsAsync:   isAsync,
 	}
 }
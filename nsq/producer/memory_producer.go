@@ -0,0 +1,79 @@
+package nsqproducer
+
+import (
+	"sync"
+	"time"
+
+	"devcode.xeemore.com/systech/gojunkyard/reporter"
+)
+
+// Message is the data recorded by MemoryProducer
+type Message struct {
+	Topic string
+	Delay time.Duration
+	Data  interface{}
+}
+
+// MemoryProducer records every published data in memory instead of sending it to nsqd. It is used for testing
+type MemoryProducer struct {
+	mu       sync.Mutex
+	messages []Message
+	err      error
+}
+
+// NewMemoryProducer will create MemoryProducer object
+func NewMemoryProducer() *MemoryProducer {
+	return new(MemoryProducer)
+}
+
+// Init does nothing
+func (p *MemoryProducer) Init() {}
+
+// SetReporter does nothing
+func (p *MemoryProducer) SetReporter(reporter reporter.Reporter) {}
+
+// SetError makes the next publish fail with err, nil makes it succeed again
+func (p *MemoryProducer) SetError(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
+
+// Publish records data published to topic
+func (p *MemoryProducer) Publish(topic string, data interface{}) error {
+	return p.DeferredPublish(topic, 0, data)
+}
+
+// MultiPublish records every data published to topic
+func (p *MemoryProducer) MultiPublish(topic string, data []interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	for _, v := range data {
+		p.messages = append(p.messages, Message{Topic: topic, Data: v})
+	}
+	return nil
+}
+
+// DeferredPublish records data published to topic with its delay
+func (p *MemoryProducer) DeferredPublish(topic string, delay time.Duration, data interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.messages = append(p.messages, Message{Topic: topic, Delay: delay, Data: data})
+	return nil
+}
+
+// Messages returns all of recorded message
+func (p *MemoryProducer) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Message(nil), p.messages...)
+}
+
+// Stop does nothing
+func (p *MemoryProducer) Stop() {}
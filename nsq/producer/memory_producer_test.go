@@ -0,0 +1,31 @@
+package nsqproducer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryProducer(t *testing.T) {
+	var p IProducer = NewMemoryProducer()
+	p.Init()
+	p.SetReporter(nil)
+	defer p.Stop()
+
+	assert.Nil(t, p.Publish("TOPIC_1", 1))
+	assert.Nil(t, p.MultiPublish("TOPIC_2", []interface{}{2, 3}))
+	assert.Nil(t, p.DeferredPublish("TOPIC_3", time.Second, 4))
+
+	p.(*MemoryProducer).SetError(errors.New("DOWN"))
+	assert.NotNil(t, p.Publish("TOPIC_1", 5))
+	assert.NotNil(t, p.MultiPublish("TOPIC_1", []interface{}{5}))
+
+	assert.Equal(t, []Message{
+		{Topic: "TOPIC_1", Data: 1},
+		{Topic: "TOPIC_2", Data: 2},
+		{Topic: "TOPIC_2", Data: 3},
+		{Topic: "TOPIC_3", Delay: time.Second, Data: 4},
+	}, p.(*MemoryProducer).Messages())
+}
@@ -0,0 +1,96 @@
+package nsqproducer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"devcode.xeemore.com/systech/gojunkyard/reporter"
+)
+
+// MultiProducer will publish the data to one of several nsqd based on Config.Balancing.
+// When publishing to an nsqd is failed, the next nsqd is tried until all of them fail
+type MultiProducer struct {
+	producers []IProducer
+	balancing string
+	next      uint32
+}
+
+// newMultiProducer creates one producer per address in cfg.NSQDs
+func newMultiProducer(cfg *Config) *MultiProducer {
+	producers := make([]IProducer, 0, len(cfg.NSQDs))
+	for _, addr := range cfg.NSQDs {
+		c := *cfg
+		c.NSQD, c.NSQDs = addr, nil
+		producers = append(producers, NewProducer(&c))
+	}
+
+	return &MultiProducer{
+		producers: producers,
+		balancing: cfg.Balancing,
+	}
+}
+
+// Init is used for initialize all of producer
+func (p *MultiProducer) Init() {
+	for _, v := range p.producers {
+		v.Init()
+	}
+}
+
+// SetReporter is used for report all data based on level
+func (p *MultiProducer) SetReporter(reporter reporter.Reporter) {
+	for _, v := range p.producers {
+		v.SetReporter(reporter)
+	}
+}
+
+// do calls f using the producer selected by balancing strategy, then the next producers until one succeeds
+func (p *MultiProducer) do(f func(IProducer) error) error {
+	var (
+		n     = uint32(len(p.producers))
+		start = atomic.LoadUint32(&p.next)
+		err   error
+	)
+	if p.balancing == BalancingRoundRobin {
+		start = atomic.AddUint32(&p.next, 1) - 1
+	}
+
+	for i := uint32(0); i < n; i++ {
+		idx := (start + i) % n
+		if err = f(p.producers[idx]); err == nil {
+			if p.balancing != BalancingRoundRobin && i > 0 {
+				atomic.StoreUint32(&p.next, idx)
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// Publish will publish data to certain topic in one of nsqd
+func (p *MultiProducer) Publish(topic string, data interface{}) error {
+	return p.do(func(producer IProducer) error {
+		return producer.Publish(topic, data)
+	})
+}
+
+// MultiPublish will publish multiple data to certain topic in one of nsqd
+func (p *MultiProducer) MultiPublish(topic string, data []interface{}) error {
+	return p.do(func(producer IProducer) error {
+		return producer.MultiPublish(topic, data)
+	})
+}
+
+// DeferredPublish will publish data to certain topic in one of nsqd which is delivered to the consumer after delay
+func (p *MultiProducer) DeferredPublish(topic string, delay time.Duration, data interface{}) error {
+	return p.do(func(producer IProducer) error {
+		return producer.DeferredPublish(topic, delay, data)
+	})
+}
+
+// Stop will stop all of producer
+func (p *MultiProducer) Stop() {
+	for _, v := range p.producers {
+		v.Stop()
+	}
+}
@@ -0,0 +1,47 @@
+package nsqproducer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiProducer_Failover(t *testing.T) {
+	nsqd := newFakeNSQD(t)
+
+	p := NewProducer(NewMultiConfig([]string{"127.0.0.1:1", nsqd.Addr()}, BalancingFailover, false))
+	assert.IsType(t, &MultiProducer{}, p)
+	p.Init()
+	defer p.Stop()
+
+	assert.Nil(t, p.Publish("TOPIC", 1))
+	assert.Nil(t, p.MultiPublish("TOPIC", []interface{}{1, 2}))
+	assert.Nil(t, p.DeferredPublish("TOPIC", time.Second, 1))
+	assert.Equal(t, []string{"IDENTIFY", "PUB TOPIC", "MPUB TOPIC", "DPUB TOPIC 1000"}, nsqd.Commands())
+
+	// the healthy nsqd is kept after failing over
+	assert.Equal(t, uint32(1), p.(*MultiProducer).next)
+}
+
+func TestMultiProducer_RoundRobin(t *testing.T) {
+	nsqd1, nsqd2 := newFakeNSQD(t), newFakeNSQD(t)
+
+	p := NewProducer(NewMultiConfig([]string{nsqd1.Addr(), nsqd2.Addr()}, BalancingRoundRobin, false))
+	p.Init()
+	defer p.Stop()
+
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, p.Publish("TOPIC", i))
+	}
+	assert.Equal(t, []string{"IDENTIFY", "PUB TOPIC", "PUB TOPIC"}, nsqd1.Commands())
+	assert.Equal(t, []string{"IDENTIFY", "PUB TOPIC", "PUB TOPIC"}, nsqd2.Commands())
+}
+
+func TestMultiProducer_AllFailed(t *testing.T) {
+	p := NewProducer(NewMultiConfig([]string{"127.0.0.1:1", "127.0.0.1:2"}, "", true))
+	p.Init()
+	defer p.Stop()
+
+	assert.NotNil(t, p.Publish("TOPIC", 1))
+}
@@ -0,0 +1,103 @@
+package nsqproducer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNSQD is minimal nsqd TCP server which acknowledges every IDENTIFY, PUB, MPUB and DPUB command
+type fakeNSQD struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newFakeNSQD(t *testing.T) *fakeNSQD {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeNSQD{listener: l}
+	go f.serve()
+	t.Cleanup(func() { l.Close() })
+	return f
+}
+
+func (f *fakeNSQD) Addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeNSQD) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func (f *fakeNSQD) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeNSQD) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+
+		switch strings.Fields(line)[0] {
+		case "IDENTIFY", "PUB", "MPUB", "DPUB":
+			var size int32
+			if err = binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if _, err = io.CopyN(ioutil.Discard, r, int64(size)); err != nil {
+				return
+			}
+		case "CLS":
+			return
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, line)
+		f.mu.Unlock()
+
+		if line == "NOP" {
+			continue
+		}
+		if err = writeFrame(conn, 0, []byte("OK")); err != nil {
+			return
+		}
+	}
+}
+
+func writeFrame(w io.Writer, frameType int32, data []byte) error {
+	buf := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(4+len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(frameType))
+	copy(buf[8:], data)
+	_, err := w.Write(buf)
+	return err
+}
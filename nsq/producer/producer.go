@@ -1,6 +1,10 @@
 package nsqproducer
 
 import (
+	"time"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
 	"devcode.xeemore.com/systech/gojunkyard/reporter"
 	"devcode.xeemore.com/systech/gojunkyard/reporter/nop"
 
@@ -10,8 +14,11 @@ import (
 // IProducer interface of producer
 type IProducer interface {
 	Init()
+	SetReporter(reporter reporter.Reporter)
 	Publish(topic string, data interface{}) error
 	MultiPublish(topic string, data []interface{}) error
+	DeferredPublish(topic string, delay time.Duration, data interface{}) error
+	Stop()
 }
 
 // Producer will handle all of producer and config
@@ -23,14 +30,18 @@ type Producer struct {
 
 // NewProducer will create Producer object
 func NewProducer(cfg *Config) IProducer {
+	if len(cfg.NSQDs) > 0 {
+		return newMultiProducer(cfg)
+	}
+
 	if cfg.IsAsync {
 		return &AsyncProducer{
 			Producer: Producer{
 				config:   cfg,
 				reporter: nop.NewNopReporter(),
-				producer: new(nsq.Producer),
 			},
 			transaction: make(chan *nsq.ProducerTransaction, 100),
+			done:        make(chan struct{}),
 		}
 	}
 
@@ -38,7 +49,41 @@ func NewProducer(cfg *Config) IProducer {
 		Producer: Producer{
 			config:   cfg,
 			reporter: nop.NewNopReporter(),
-			producer: new(nsq.Producer),
 		},
 	}
 }
+
+// IsAsync returns true when p publishes asynchronously, e.g. the AsyncProducer or the MultiProducer of
+// the AsyncProducer. Its nil error does not mean nsqd has received the message
+func IsAsync(p IProducer) bool {
+	switch p := p.(type) {
+	case *AsyncProducer:
+		return true
+	case *MultiProducer:
+		for _, v := range p.producers {
+			if IsAsync(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReporter is used for report all data based on level
+func (p *Producer) SetReporter(reporter reporter.Reporter) {
+	p.reporter = reporter
+}
+
+// marshal encodes data using the codec configured for the topic. *nsqenvelope.Envelope is wrapped with its headers
+func (p *Producer) marshal(topic string, data interface{}) ([]byte, error) {
+	name, ok := p.config.Codecs[topic]
+	if !ok {
+		name = p.config.DefaultCodec
+	}
+
+	codec, err := nsqcodec.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return nsqenvelope.Marshal(codec, data)
+}
@@ -0,0 +1,50 @@
+package nsqproducer
+
+import (
+	"testing"
+
+	nsqcodec "devcode.xeemore.com/systech/gojunkyard/nsq/codec"
+	nsqenvelope "devcode.xeemore.com/systech/gojunkyard/nsq/envelope"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProducer_marshal(t *testing.T) {
+	p := &Producer{config: &Config{
+		Codecs: map[string]string{"COMPRESSED": "gzip+json", "UNKNOWN": "xml"},
+	}}
+
+	b, err := p.marshal("PLAIN", map[string]int{"id": 1})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":1}`, string(b))
+
+	b, err = p.marshal("COMPRESSED", map[string]int{"id": 1})
+	assert.Nil(t, err)
+	var got map[string]int
+	assert.Nil(t, nsqcodec.GzipJSON.Unmarshal(b, &got))
+	assert.Equal(t, map[string]int{"id": 1}, got)
+
+	_, err = p.marshal("UNKNOWN", 1)
+	assert.NotNil(t, err)
+
+	p.config.DefaultCodec = "msgpack"
+	b, err = p.marshal("PLAIN", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x01}, b)
+
+	b, err = p.marshal("COMPRESSED", (&nsqenvelope.Envelope{Data: map[string]int{"id": 1}}).WithHeader("tenant", "xeemore"))
+	assert.Nil(t, err)
+	headers, payload, err := nsqenvelope.Decode(b)
+	assert.Nil(t, err)
+	assert.Equal(t, nsqenvelope.Headers{"tenant": "xeemore"}, headers)
+	assert.Nil(t, nsqcodec.GzipJSON.Unmarshal(payload, &got))
+	assert.Equal(t, map[string]int{"id": 1}, got)
+}
+
+func TestIsAsync(t *testing.T) {
+	assert.False(t, IsAsync(NewProducer(&Config{NSQD: "127.0.0.1:4150"})))
+	assert.False(t, IsAsync(NewMemoryProducer()))
+	assert.True(t, IsAsync(NewProducer(&Config{NSQD: "127.0.0.1:4150", IsAsync: true})))
+	assert.False(t, IsAsync(NewProducer(&Config{NSQDs: []string{"127.0.0.1:4150", "127.0.0.1:4250"}})))
+	assert.True(t, IsAsync(NewProducer(&Config{NSQDs: []string{"127.0.0.1:4150", "127.0.0.1:4250"}, IsAsync: true})))
+}
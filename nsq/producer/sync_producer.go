@@ -1,8 +1,8 @@
 package nsqproducer
 
 import (
-	"encoding/json"
 	"fmt"
+	"time"
 
 	nsq "github.com/nsqio/go-nsq"
 )
@@ -27,7 +27,7 @@ func (p *SyncProducer) Init() {
 func (p *SyncProducer) MultiPublish(topic string, data []interface{}) error {
 	bb := make([][]byte, 0, len(data))
 	for _, v := range data {
-		b, err := json.Marshal(v)
+		b, err := p.marshal(topic, v)
 		if err != nil {
 			p.reporter.Errorf(
 				"[NSQ] Producer async failed marshaling data. topic: %s, message: %+v, err: %v",
@@ -52,7 +52,7 @@ func (p *SyncProducer) MultiPublish(topic string, data []interface{}) error {
 
 // Publish will publish data to certain topic in nsq
 func (p *SyncProducer) Publish(topic string, data interface{}) error {
-	b, err := json.Marshal(data)
+	b, err := p.marshal(topic, data)
 	if err != nil {
 		p.reporter.Errorf(
 			"[NSQ] Producer failed marshaling data. topic: %s, message: %+v, err: %v",
@@ -72,3 +72,33 @@ func (p *SyncProducer) Publish(topic string, data interface{}) error {
 
 	return nil
 }
+
+// DeferredPublish will publish data to certain topic in nsq which is delivered to the consumer after delay
+func (p *SyncProducer) DeferredPublish(topic string, delay time.Duration, data interface{}) error {
+	b, err := p.marshal(topic, data)
+	if err != nil {
+		p.reporter.Errorf(
+			"[NSQ] Producer failed marshaling data. topic: %s, message: %+v, err: %v",
+			topic, data, err,
+		)
+		return err
+	}
+
+	err = p.producer.DeferredPublish(topic, delay, b)
+	if err != nil {
+		p.reporter.Errorf(
+			"[NSQ] Producer failed deferred publish data. topic: %s, delay: %s, message: %+v, err: %v",
+			topic, delay, data, err,
+		)
+		return err
+	}
+
+	return nil
+}
+
+// Stop will disconnect the producer from nsqd
+func (p *SyncProducer) Stop() {
+	if p.producer != nil {
+		p.producer.Stop()
+	}
+}
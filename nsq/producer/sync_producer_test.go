@@ -0,0 +1,20 @@
+package nsqproducer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncProducer_DeferredPublish(t *testing.T) {
+	nsqd := newFakeNSQD(t)
+
+	p := NewProducer(NewConfig(nsqd.Addr(), false))
+	p.Init()
+	defer p.Stop()
+
+	assert.Nil(t, p.DeferredPublish("TOPIC", 1500*time.Millisecond, 1))
+	assert.NotNil(t, p.DeferredPublish("TOPIC", time.Second, func() {}))
+	assert.Equal(t, []string{"IDENTIFY", "DPUB TOPIC 1500"}, nsqd.Commands())
+}
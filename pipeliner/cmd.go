@@ -1,10 +1,17 @@
 package pipeliner
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 type pipelinerCmd struct {
-	v     interface{}
-	resCh chan error
+	v         interface{}
+	res       interface{}
+	err       error
+	queuedAt  time.Time
+	followers []*pipelinerCmd
+	resCh     chan error
 }
 
 var pipelinerCmdPool sync.Pool
@@ -18,5 +25,7 @@ func getPipelinerCmd() *pipelinerCmd {
 }
 
 func putPipelinerCmd(cmd *pipelinerCmd) {
+	cmd.v, cmd.res, cmd.err = nil, nil, nil
+	cmd.followers = cmd.followers[:0]
 	pipelinerCmdPool.Put(cmd)
 }
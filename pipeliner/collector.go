@@ -0,0 +1,171 @@
+package pipeliner
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultLatencyBuckets is the histogram buckets (in seconds) used for wait and doer latency
+	DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+	// DefaultFillRatioBuckets is the histogram buckets used for batch size divided by the window limit
+	DefaultFillRatioBuckets = []float64{.1, .2, .3, .4, .5, .6, .7, .8, .9, 1}
+)
+
+// Collector is an Observer which aggregates the pipeliner metrics and exposes them in
+// the prometheus text exposition format through ServeHTTP or WriteTo
+type Collector struct {
+	name string
+
+	mu          sync.Mutex
+	flushes     map[FlushReason]uint64
+	doerErrors  uint64
+	batchSize   *histogram
+	fillRatio   *histogram
+	slotWait    *histogram
+	queueWait   *histogram
+	doerLatency *histogram
+}
+
+// NewCollector creates a collector whose metrics are prefixed by name, e.g. "user_lookup"
+// produces "user_lookup_pipeliner_flush_total"
+func NewCollector(name string) *Collector {
+	prefix := "pipeliner"
+	if name != "" {
+		prefix = name + "_pipeliner"
+	}
+	return &Collector{
+		name:        prefix,
+		flushes:     make(map[FlushReason]uint64),
+		batchSize:   newHistogram([]float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}),
+		fillRatio:   newHistogram(DefaultFillRatioBuckets),
+		slotWait:    newHistogram(DefaultLatencyBuckets),
+		queueWait:   newHistogram(DefaultLatencyBuckets),
+		doerLatency: newHistogram(DefaultLatencyBuckets),
+	}
+}
+
+// ObserveFlush implements Observer
+func (c *Collector) ObserveFlush(reason FlushReason, size, limit int) {
+	c.mu.Lock()
+	c.flushes[reason]++
+	c.batchSize.observe(float64(size))
+	if limit > 0 {
+		c.fillRatio.observe(float64(size) / float64(limit))
+	}
+	c.mu.Unlock()
+}
+
+// ObserveSlotWait implements Observer
+func (c *Collector) ObserveSlotWait(d time.Duration) {
+	c.mu.Lock()
+	c.slotWait.observe(d.Seconds())
+	c.mu.Unlock()
+}
+
+// ObserveQueueWait implements Observer
+func (c *Collector) ObserveQueueWait(d time.Duration) {
+	c.mu.Lock()
+	c.queueWait.observe(d.Seconds())
+	c.mu.Unlock()
+}
+
+// ObserveDoer implements Observer
+func (c *Collector) ObserveDoer(size int, d time.Duration, err error) {
+	c.mu.Lock()
+	c.doerLatency.observe(d.Seconds())
+	if err != nil {
+		c.doerErrors++
+	}
+	c.mu.Unlock()
+}
+
+// ServeHTTP writes the metrics in the prometheus text exposition format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the prometheus text exposition format to w
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cw := &countWriter{w: w}
+
+	fmt.Fprintf(cw, "# HELP %s_flush_total Number of flushed windows by reason.\n", c.name)
+	fmt.Fprintf(cw, "# TYPE %s_flush_total counter\n", c.name)
+	reasons := make([]string, 0, len(c.flushes))
+	for reason := range c.flushes {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(cw, "%s_flush_total{reason=%q} %d\n", c.name, reason, c.flushes[FlushReason(reason)])
+	}
+
+	fmt.Fprintf(cw, "# HELP %s_doer_errors_total Number of doer executions returning an error.\n", c.name)
+	fmt.Fprintf(cw, "# TYPE %s_doer_errors_total counter\n", c.name)
+	fmt.Fprintf(cw, "%s_doer_errors_total %d\n", c.name, c.doerErrors)
+
+	c.batchSize.write(cw, c.name+"_batch_size", "Number of commands in a flushed window.")
+	c.fillRatio.write(cw, c.name+"_batch_fill_ratio", "Number of commands in a flushed window divided by the limit.")
+	c.slotWait.write(cw, c.name+"_slot_wait_seconds", "Time spent waiting for a free concurrency slot.")
+	c.queueWait.write(cw, c.name+"_queue_wait_seconds", "Time between a command entering the window and the doer call.")
+	c.doerLatency.write(cw, c.name+"_doer_duration_seconds", "Doer execution latency.")
+
+	return cw.n, cw.err
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(upper, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,49 @@
+package pipeliner
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector("testing")
+	pipe := New(func(v []int) error {
+		if v[0] < 0 {
+			return errors.New("NEGATIVE")
+		}
+		return nil
+	}, SetConcurrency(1), SetWindow(100*time.Millisecond, 2), SetObserver(collector))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pipe.Do(1)
+	}()
+	go func() {
+		defer wg.Done()
+		pipe.Do(1)
+	}()
+	wg.Wait()
+	assert.Equal(t, errors.New("NEGATIVE"), pipe.Do(-1))
+
+	rec := httptest.NewRecorder()
+	collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+	assert.Contains(t, body, `testing_pipeliner_flush_total{reason="limit"} 1`)
+	assert.Contains(t, body, `testing_pipeliner_flush_total{reason="window"} 1`)
+	assert.Contains(t, body, "testing_pipeliner_doer_errors_total 1\n")
+	assert.Contains(t, body, `testing_pipeliner_batch_fill_ratio_bucket{le="0.5"} 1`)
+	assert.Contains(t, body, `testing_pipeliner_batch_fill_ratio_bucket{le="1"} 2`)
+	assert.Contains(t, body, "testing_pipeliner_queue_wait_seconds_count 3\n")
+	assert.Contains(t, body, "testing_pipeliner_doer_duration_seconds_count 2\n")
+}
@@ -2,12 +2,25 @@ package pipeliner
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"sync"
 )
 
+// ErrResultMismatch is returned to every caller in a batch when the per-item doer returns
+// results or errors whose length differs from the number of commands
+var ErrResultMismatch = errors.New("pipeliner: doer returned mismatched results length")
+
 type doer func(context.Context, []*pipelinerCmd) error
 
+var (
+	contextType    = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+	errorSliceType = reflect.TypeOf([]error(nil))
+)
+
+const doerSignature = `f must be "func([]T) error", "func(context.Context, []T) error", "func([]T) ([]R, []error)" or "func(context.Context, []T) ([]R, []error)"`
+
 func getdoer(f interface{}) doer {
 	fv := reflect.ValueOf(f)
 	ft := fv.Type()
@@ -17,15 +30,20 @@ func getdoer(f interface{}) doer {
 		(ft.NumIn() == 1 &&
 			ft.In(0).Kind() != reflect.Slice) ||
 		(ft.NumIn() == 2 &&
-			ft.In(0) != reflect.TypeOf((*context.Context)(nil)).Elem() &&
-			ft.In(1).Kind() != reflect.Slice) ||
-		ft.NumOut() != 1 ||
-		ft.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-		panic(`f must be "func([]T) error" or "func(context.Context, []T)" error`)
+			(ft.In(0) != contextType ||
+				ft.In(1).Kind() != reflect.Slice)) ||
+		(ft.NumOut() == 1 &&
+			ft.Out(0) != errorType) ||
+		(ft.NumOut() == 2 &&
+			(ft.Out(0).Kind() != reflect.Slice ||
+				ft.Out(1) != errorSliceType)) ||
+		(ft.NumOut() < 1 || ft.NumOut() > 2) {
+		panic(doerSignature)
 	}
 
 	var (
 		contextEnabled = ft.NumIn() == 2
+		perItem        = ft.NumOut() == 2
 		dataIdx        = ft.NumIn() - 1
 		pool           sync.Pool
 	)
@@ -38,6 +56,8 @@ func getdoer(f interface{}) doer {
 			_in.Elem().Set(reflect.MakeSlice(_in.Elem().Type(), 0, len(cmds)))
 			in = &_in
 		}
+		// the slice goes back to the pool after the results are distributed, the doer may return it as the results
+		defer pool.Put(in)
 
 		// step 2. reset the slice before use
 		in.Elem().SetLen(0)
@@ -48,14 +68,36 @@ func getdoer(f interface{}) doer {
 		}
 
 		// step 4. execute the function
-		var err error
+		var out []reflect.Value
 		if contextEnabled {
-			err, _ = fv.Call([]reflect.Value{reflect.ValueOf(ctx), in.Elem()})[0].Interface().(error)
+			out = fv.Call([]reflect.Value{reflect.ValueOf(ctx), in.Elem()})
 		} else {
-			err, _ = fv.Call([]reflect.Value{in.Elem()})[0].Interface().(error)
+			out = fv.Call([]reflect.Value{in.Elem()})
+		}
+
+		if !perItem {
+			err, _ := out[0].Interface().(error)
+			return err
+		}
+
+		// step 5. distribute the result and error for each command
+		var (
+			results = out[0]
+			errs, _ = out[1].Interface().([]error)
+		)
+		if (!results.IsNil() && results.Len() != len(cmds)) ||
+			(errs != nil && len(errs) != len(cmds)) {
+			return ErrResultMismatch
+		}
+		for i, cmd := range cmds {
+			if !results.IsNil() {
+				cmd.res = results.Index(i).Interface()
+			}
+			if errs != nil {
+				cmd.err = errs[i]
+			}
 		}
-		pool.Put(in)
-		return err
+		return nil
 	}
 
 }
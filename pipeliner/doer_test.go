@@ -11,9 +11,9 @@ import (
 func Test_getdoer(t *testing.T) {
 	type x struct{}
 
-	assert.PanicsWithValue(t, `f must be "func([]T) error" or "func(context.Context, []T)" error`, func() { getdoer(1) })
-	assert.PanicsWithValue(t, `f must be "func([]T) error" or "func(context.Context, []T)" error`, func() { getdoer(func() {}) })
-	assert.PanicsWithValue(t, `f must be "func([]T) error" or "func(context.Context, []T)" error`, func() { getdoer(func() error { return nil }) })
+	assert.PanicsWithValue(t, doerSignature, func() { getdoer(1) })
+	assert.PanicsWithValue(t, doerSignature, func() { getdoer(func() {}) })
+	assert.PanicsWithValue(t, doerSignature, func() { getdoer(func() error { return nil }) })
 
 	doer := getdoer(func(ctx context.Context, i []int) error { return nil })
 	assert.Nil(t, doer(context.Background(), []*pipelinerCmd{{v: 1, resCh: make(chan error)}, {v: 1, resCh: make(chan error)}}))
@@ -35,6 +35,29 @@ func Test_getdoer(t *testing.T) {
 
 	doer = getdoer(func(i []int) error { return nil })
 	assert.Panics(t, func() { doer(context.Background(), []*pipelinerCmd{{v: "abc", resCh: make(chan error)}}) })
+
+	assert.PanicsWithValue(t, doerSignature, func() { getdoer(func(i []int) ([]int, error) { return nil, nil }) })
+	assert.PanicsWithValue(t, doerSignature, func() { getdoer(func(i []int) (int, []error) { return 0, nil }) })
+	assert.PanicsWithValue(t, doerSignature, func() { getdoer(func(ctx int, i []int) error { return nil }) })
+
+	cmds := []*pipelinerCmd{{v: 1}, {v: 2}}
+	doer = getdoer(func(ctx context.Context, i []int) ([]string, []error) {
+		return []string{"one", "two"}, []error{nil, errors.New("ahuehue")}
+	})
+	assert.Nil(t, doer(context.Background(), cmds))
+	assert.Equal(t, "one", cmds[0].res)
+	assert.Nil(t, cmds[0].err)
+	assert.Equal(t, "two", cmds[1].res)
+	assert.Equal(t, errors.New("ahuehue"), cmds[1].err)
+
+	cmds = []*pipelinerCmd{{v: 1}, {v: 2}}
+	doer = getdoer(func(i []int) ([]int, []error) { return i, nil })
+	assert.Nil(t, doer(context.Background(), cmds))
+	assert.Equal(t, 1, cmds[0].res)
+	assert.Equal(t, 2, cmds[1].res)
+
+	doer = getdoer(func(i []int) ([]int, []error) { return i[:1], nil })
+	assert.Equal(t, ErrResultMismatch, doer(context.Background(), []*pipelinerCmd{{v: 1}, {v: 2}}))
 }
 
 func BenchmarkDoer(b *testing.B) {
@@ -0,0 +1,34 @@
+package pipeliner
+
+import "time"
+
+// FlushReason describes why a window has been flushed to the doer
+type FlushReason string
+
+// List of FlushReason
+const (
+	FlushLimit  FlushReason = "limit"
+	FlushWindow FlushReason = "window"
+	FlushClose  FlushReason = "close"
+)
+
+// Observer is notified by the pipeliner about its batching behaviour. It is called synchronously
+// from the pipeliner goroutines, so the implementation must be cheap and safe for concurrent use
+type Observer interface {
+	// ObserveFlush is called each time a window is flushed with the number of commands in it
+	ObserveFlush(reason FlushReason, size, limit int)
+	// ObserveSlotWait is called with the time spent waiting for a free concurrency slot after a flush
+	ObserveSlotWait(d time.Duration)
+	// ObserveQueueWait is called for every command with the time between it entering the window and
+	// the doer being called
+	ObserveQueueWait(d time.Duration)
+	// ObserveDoer is called after every doer execution
+	ObserveDoer(size int, d time.Duration, err error)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveFlush(FlushReason, int, int)    {}
+func (nopObserver) ObserveSlotWait(time.Duration)         {}
+func (nopObserver) ObserveQueueWait(time.Duration)        {}
+func (nopObserver) ObserveDoer(int, time.Duration, error) {}
@@ -2,10 +2,14 @@ package pipeliner
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 )
 
+// ErrClosed is returned by Do when the pipeliner has been closed
+var ErrClosed = errors.New("pipeliner: closed")
+
 // Pipeliner ...
 type Pipeliner struct {
 	window    time.Duration
@@ -15,6 +19,13 @@ type Pipeliner struct {
 	reqsBufCh chan []*pipelinerCmd
 	reqCh     chan *pipelinerCmd
 	timeout   time.Duration
+	observer  Observer
+	keyFn     func(interface{}) interface{}
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	wg        sync.WaitGroup
 }
 
 // Option ...
@@ -42,9 +53,30 @@ func SetConcurrency(size int) Option {
 	}
 }
 
+// SetObserver sets the observer notified about flushes, queue wait and doer latency
+func SetObserver(observer Observer) Option {
+	return func(pipeliner *Pipeliner) {
+		pipeliner.observer = observer
+	}
+}
+
+// SetCoalesce deduplicates the commands within a window by the key returned by keyFn. Only the first
+// command of each key is passed to the doer and its result and error are shared with every duplicate
+func SetCoalesce(keyFn func(v interface{}) interface{}) Option {
+	return func(pipeliner *Pipeliner) {
+		pipeliner.keyFn = keyFn
+	}
+}
+
 // New ...
 func New(f interface{}, opts ...Option) *Pipeliner {
-	pipeliner := &Pipeliner{doer: getdoer(f), reqCh: make(chan *pipelinerCmd)}
+	pipeliner := &Pipeliner{
+		doer:     getdoer(f),
+		reqCh:    make(chan *pipelinerCmd),
+		closeCh:  make(chan struct{}),
+		doneCh:   make(chan struct{}),
+		observer: nopObserver{},
+	}
 	for _, opt := range opts {
 		opt(pipeliner)
 	}
@@ -61,10 +93,22 @@ func New(f interface{}, opts ...Option) *Pipeliner {
 }
 
 func (p *Pipeliner) loop() {
+	defer close(p.doneCh)
+
 	t := time.NewTimer(0)
 	t.Stop()
 
-	reqs := <-p.reqsBufCh
+	var reqs []*pipelinerCmd
+	select {
+	case reqs = <-p.reqsBufCh:
+	case <-p.closeCh:
+		return
+	}
+
+	var leaders map[interface{}]*pipelinerCmd
+	if p.keyFn != nil {
+		leaders = make(map[interface{}]*pipelinerCmd)
+	}
 
 	for {
 		select {
@@ -73,29 +117,55 @@ func (p *Pipeliner) loop() {
 				continue
 			}
 
+			if leaders != nil {
+				key := p.keyFn(req.v)
+				if leader, ok := leaders[key]; ok {
+					leader.followers = append(leader.followers, req)
+					continue
+				}
+				leaders[key] = req
+			}
+
+			req.queuedAt = time.Now()
 			reqs = append(reqs, req)
 			if p.limit > 0 && len(reqs) == p.limit {
 				t.Stop()
-				reqs = p.flush(reqs)
+				reqs = p.flush(reqs, FlushLimit)
+				resetLeaders(leaders)
 			} else if len(reqs) == 1 {
 				t.Reset(p.window)
 			}
 		case <-t.C:
-			reqs = p.flush(reqs)
+			reqs = p.flush(reqs, FlushWindow)
+			resetLeaders(leaders)
+		case <-p.closeCh:
+			t.Stop()
+			p.flush(reqs, FlushClose)
+			return
 		}
 	}
 }
 
+func resetLeaders(leaders map[interface{}]*pipelinerCmd) {
+	for key := range leaders {
+		delete(leaders, key)
+	}
+}
+
 var ifacepool sync.Pool
 
-func (p *Pipeliner) flush(reqs []*pipelinerCmd) []*pipelinerCmd {
+func (p *Pipeliner) flush(reqs []*pipelinerCmd, reason FlushReason) []*pipelinerCmd {
 	if len(reqs) == 0 {
 		return reqs
 	}
 
+	p.observer.ObserveFlush(reason, len(reqs), p.limit)
+
+	p.wg.Add(1)
 	go func() {
 		defer func() {
 			p.reqsBufCh <- reqs[:0]
+			p.wg.Done()
 		}()
 
 		ctx := context.Background()
@@ -105,23 +175,95 @@ func (p *Pipeliner) flush(reqs []*pipelinerCmd) []*pipelinerCmd {
 			defer cancel()
 		}
 
+		start := time.Now()
+		for _, req := range reqs {
+			p.observer.ObserveQueueWait(start.Sub(req.queuedAt))
+		}
+
 		err := p.doer(ctx, reqs)
+		p.observer.ObserveDoer(len(reqs), time.Since(start), err)
 		for _, req := range reqs {
-			req.resCh <- err
+			if err != nil {
+				req.res, req.err = nil, err
+			}
+			// followers must be answered before the leader, since the leader goes back to the pool
+			for _, follower := range req.followers {
+				follower.res = req.res
+				follower.resCh <- req.err
+			}
+			req.resCh <- req.err
 		}
 	}()
 
-	return <-p.reqsBufCh
+	start := time.Now()
+	next := <-p.reqsBufCh
+	p.observer.ObserveSlotWait(time.Since(start))
+	return next
 }
 
 // Do ...
 func (p *Pipeliner) Do(v interface{}) error {
+	_, err := p.DoResultContext(context.Background(), v)
+	return err
+}
+
+// DoContext is like Do but gives up when ctx is done before the batch containing v completes
+func (p *Pipeliner) DoContext(ctx context.Context, v interface{}) error {
+	_, err := p.DoResultContext(ctx, v)
+	return err
+}
+
+// DoResult queues v and returns the result and error specific to v. The result is always nil
+// unless the pipeliner is built with "func([]T) ([]R, []error)" or
+// "func(context.Context, []T) ([]R, []error)"
+func (p *Pipeliner) DoResult(v interface{}) (interface{}, error) {
+	return p.DoResultContext(context.Background(), v)
+}
+
+// DoResultContext is like DoResult but gives up when ctx is done before the batch containing v completes
+func (p *Pipeliner) DoResultContext(ctx context.Context, v interface{}) (interface{}, error) {
 	cmd := getPipelinerCmd()
 	cmd.v = v
 
-	p.reqCh <- cmd
-	err := <-cmd.resCh
+	select {
+	case p.reqCh <- cmd:
+	case <-p.closeCh:
+		putPipelinerCmd(cmd)
+		return nil, ErrClosed
+	case <-ctx.Done():
+		putPipelinerCmd(cmd)
+		return nil, ctx.Err()
+	}
 
-	putPipelinerCmd(cmd)
-	return err
+	select {
+	case err := <-cmd.resCh:
+		res := cmd.res
+		putPipelinerCmd(cmd)
+		return res, err
+	case <-ctx.Done():
+		// cmd is still owned by the doer, so it must not go back to the pool
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops accepting new commands, flushes the pending window and waits for every in-flight
+// doer to complete or ctx to be done
+func (p *Pipeliner) Close(ctx context.Context) error {
+	p.closeOnce.Do(func() {
+		close(p.closeCh)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		<-p.doneCh
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
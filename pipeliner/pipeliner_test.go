@@ -54,3 +54,120 @@ func Test_Window(t *testing.T) {
 
 	assert.True(t, time.Since(now) > 100*time.Microsecond, "Queue must be more than 1 microsecond")
 }
+
+func Test_DoResult(t *testing.T) {
+	pipe := New(func(v []int) ([]int, []error) {
+		res, errs := make([]int, len(v)), make([]error, len(v))
+		for i := range v {
+			if v[i] < 0 {
+				errs[i] = errors.New("NEGATIVE")
+				continue
+			}
+			res[i] = v[i] * 2
+		}
+		return res, errs
+	}, SetConcurrency(2), SetTimeout(time.Second), SetWindow(time.Millisecond, 2))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		res, err := pipe.DoResult(2)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, res)
+	}()
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, errors.New("NEGATIVE"), pipe.Do(-1))
+	}()
+	wg.Wait()
+}
+
+func Test_DoResultConcurrent(t *testing.T) {
+	// the doer returns its input slice, which must not be reused by the next batch before the results are read
+	pipe := New(func(v []int) ([]int, []error) { return v, nil }, SetConcurrency(8), SetWindow(time.Microsecond, 4))
+	defer pipe.Close(context.Background())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := pipe.DoResult(i)
+			assert.Nil(t, err)
+			assert.Equal(t, i, res)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func Test_DoContext(t *testing.T) {
+	release := make(chan struct{})
+	pipe := New(func([]int) error { <-release; return nil }, SetConcurrency(1), SetWindow(time.Millisecond, 1))
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pipe.DoContext(ctx, 1))
+}
+
+func Test_Close(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []int
+	)
+	pipe := New(func(v []int) error {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		seen = append(seen, v...)
+		mu.Unlock()
+		return nil
+	}, SetConcurrency(2), SetWindow(time.Hour, 10))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, pipe.Do(1))
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Nil(t, pipe.Close(context.Background()))
+	wg.Wait()
+	assert.Equal(t, []int{1}, seen)
+	assert.Equal(t, ErrClosed, pipe.Do(2))
+	assert.Nil(t, pipe.Close(context.Background()))
+}
+
+func Test_Coalesce(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls [][]int
+	)
+	pipe := New(func(v []int) ([]int, []error) {
+		mu.Lock()
+		calls = append(calls, append([]int(nil), v...))
+		mu.Unlock()
+
+		res := make([]int, len(v))
+		for i := range v {
+			res[i] = v[i] * 10
+		}
+		return res, nil
+	}, SetConcurrency(1), SetWindow(50*time.Millisecond, 10), SetCoalesce(func(v interface{}) interface{} { return v }))
+
+	var wg sync.WaitGroup
+	for _, v := range []int{1, 1, 1, 2} {
+		wg.Add(1)
+		go func(v int) {
+			defer wg.Done()
+			res, err := pipe.DoResult(v)
+			assert.Nil(t, err)
+			assert.Equal(t, v*10, res)
+		}(v)
+	}
+	wg.Wait()
+
+	assert.Len(t, calls, 1)
+	assert.ElementsMatch(t, []int{1, 2}, calls[0])
+}